@@ -0,0 +1,39 @@
+package bilicache
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// IndexJson Android客户端清晰度目录中的分段信息
+const IndexJson = "index.json"
+
+// BlvIndex 旧版Android客户端分段flv缓存的 index.json
+type BlvIndex struct {
+	From        string       `json:"from"`
+	Quality     int          `json:"quality"`
+	TypeTag     string       `json:"type_tag"`
+	Description string       `json:"description"`
+	SegmentList []BlvSegment `json:"segment_list"`
+}
+
+// BlvSegment 一个.blv分段，按列表顺序对应 0.blv、1.blv……
+type BlvSegment struct {
+	URL      string `json:"url"`
+	Duration int64  `json:"duration"` // 毫秒
+	Bytes    int64  `json:"bytes"`
+	Md5      string `json:"md5"`
+}
+
+// ReadBlvIndex 读取分段flv缓存的 index.json
+func ReadBlvIndex(path string) (*BlvIndex, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := &BlvIndex{}
+	if err = json.Unmarshal(b, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
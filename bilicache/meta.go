@@ -0,0 +1,266 @@
+package bilicache
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 缓存元数据文件名
+const (
+	VideoInfoJson = "videoInfo.json" // PC客户端
+	VideoInfo     = ".videoInfo"     // 旧版PC客户端
+	EntryJson     = "entry.json"     // Android客户端
+	PlayUrlFile   = ".playurl"       // PC客户端的音视频流信息
+)
+
+// ID 兼容数字和字符串两种写法的ID
+type ID string
+
+func (id *ID) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*id = ""
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*id = ID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = ID(n.String())
+	return nil
+}
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// VideoMeta 各种缓存元数据统一后的视频信息
+type VideoMeta struct {
+	GroupTitle string // 合集或番剧名称
+	Title      string
+	Part       string // 分P名称
+	Uname      string // UP主，为空时沿用标题，用于输出目录名
+	Status     string // 缓存状态，completed、视频已缓存完成或为空表示已完成
+	ItemId     string
+	GroupId    string
+	Uid        string
+	Bvid       string
+	Cid        string // 用于下载弹幕，PC客户端以目录名作为cid，此处为空
+	Cover      string // 封面的本地路径或URL
+	CoverPath  string // 封面的本地路径，可能不存在
+	Uploader   string // UP主名称，未知时为空，不像Uname那样沿用标题
+	Page       int    // 分P或剧集的序号，未知时为0
+	PubDate    int64  // 发布时间，Unix时间戳，未知时为0
+}
+
+// Completed 缓存是否已完成
+func (m *VideoMeta) Completed() bool {
+	return m.Status == "completed" || m.Status == "视频已缓存完成" || m.Status == ""
+}
+
+// PCVideoInfo PC客户端的 videoInfo.json 和 .videoInfo
+type PCVideoInfo struct {
+	Type       int    `json:"type"`
+	GroupTitle string `json:"groupTitle"`
+	Title      string `json:"title"`
+	Uname      string `json:"uname"`
+	OwnerName  string `json:"owner_name"`
+	Status     string `json:"status"`
+	ItemId     ID     `json:"itemId"`
+	GroupId    ID     `json:"groupId"`
+	Uid        ID     `json:"uid"`
+	Aid        ID     `json:"aid"`
+	Bvid       string `json:"bvid"`
+	Cid        ID     `json:"cid"`
+	P          int    `json:"p"`
+	CoverUrl   string `json:"coverUrl"`
+	CoverPath  string `json:"coverPath"`
+	PubDate    int64  `json:"pubDate"`
+	PageData   struct {
+		DownloadTitle    string `json:"download_title"`
+		DownloadSubtitle string `json:"download_subtitle"`
+	} `json:"page_data"`
+}
+
+// Meta 转换为统一的视频信息，缺少的字段与原来一样依次沿用owner_name、标题和page_data中的内容
+func (v *PCVideoInfo) Meta() *VideoMeta {
+	return &VideoMeta{
+		GroupTitle: cmp.Or(v.GroupTitle, v.OwnerName),
+		Title:      cmp.Or(v.PageData.DownloadSubtitle, v.Title),
+		Uname:      cmp.Or(v.Uname, v.Title),
+		Status:     cmp.Or(v.Status, v.PageData.DownloadTitle),
+		ItemId:     v.ItemId.String(),
+		GroupId:    v.GroupId.String(),
+		Uid:        v.Uid.String(),
+		Bvid:       v.Bvid,
+		Cover:      v.CoverUrl,
+		CoverPath:  v.CoverPath,
+		Uploader:   v.Uname,
+		Page:       v.P,
+		PubDate:    v.PubDate,
+	}
+}
+
+// AndroidEntry Android客户端的 entry.json，普通视频有page_data，番剧有ep和season_id
+type AndroidEntry struct {
+	MediaType   int    `json:"media_type"` // 1为分段flv(.blv)，2为dash(.m4s)
+	IsCompleted *bool  `json:"is_completed"`
+	Title       string `json:"title"`
+	TypeTag     string `json:"type_tag"` // 清晰度目录名，如 16、32、64、80、112、116
+	Cover       string `json:"cover"`
+	OwnerId     ID     `json:"owner_id"`
+	OwnerName   string `json:"owner_name"`
+	Avid        ID     `json:"avid"`
+	Bvid        string `json:"bvid"`
+	SeasonId    ID     `json:"season_id"`
+	PageData    *struct {
+		Cid              ID     `json:"cid"`
+		Page             int    `json:"page"`
+		Part             string `json:"part"`
+		DownloadTitle    string `json:"download_title"`
+		DownloadSubtitle string `json:"download_subtitle"`
+	} `json:"page_data"`
+	Ep *struct {
+		AvId       ID     `json:"av_id"`
+		Page       int    `json:"page"`
+		Danmaku    ID     `json:"danmaku"` // 弹幕ID，即cid
+		EpisodeId  ID     `json:"episode_id"`
+		Index      string `json:"index"`
+		IndexTitle string `json:"index_title"`
+		Bvid       string `json:"bvid"`
+		Cover      string `json:"cover"`
+	} `json:"ep"`
+	Source *struct {
+		AvId ID `json:"av_id"`
+		Cid  ID `json:"cid"`
+	} `json:"source"`
+}
+
+// Bangumi 是否为番剧、影视等剧集
+func (e *AndroidEntry) Bangumi() bool {
+	return e.Ep != nil
+}
+
+// Cid 视频的cid，用于下载弹幕
+func (e *AndroidEntry) Cid() string {
+	switch {
+	case e.PageData != nil && e.PageData.Cid != "":
+		return e.PageData.Cid.String()
+	case e.Ep != nil && e.Ep.Danmaku != "":
+		return e.Ep.Danmaku.String()
+	case e.Source != nil:
+		return e.Source.Cid.String()
+	}
+	return ""
+}
+
+// Meta 转换为统一的视频信息。entry.json没有UP主名称，沿用标题作为UP主
+func (e *AndroidEntry) Meta() *VideoMeta {
+	m := &VideoMeta{
+		GroupTitle: e.OwnerName,
+		Title:      e.Title,
+		Uname:      e.Title,
+		ItemId:     e.OwnerId.String(),
+		GroupId:    e.Avid.String(),
+		Uid:        e.OwnerId.String(),
+		Bvid:       e.Bvid,
+		Cid:        e.Cid(),
+		Cover:      e.Cover,
+		Uploader:   e.OwnerName,
+	}
+	if p := e.PageData; p != nil {
+		m.Page = p.Page
+		if p.DownloadSubtitle != "" {
+			m.Title = p.DownloadSubtitle
+		}
+		m.Part = p.Part
+		m.Status = p.DownloadTitle
+	}
+	if ep := e.Ep; ep != nil {
+		// 番剧以剧集名称分组，每集使用序号和单集标题命名，与PC客户端一致
+		m.GroupTitle, m.Uname = e.Title, cmp.Or(e.OwnerName, e.Title)
+		m.GroupId = e.SeasonId.String()
+		m.Page = ep.Page
+		if n, err := strconv.Atoi(ep.Index); err == nil {
+			m.Page = n
+		}
+		m.Part = strings.TrimSpace(episodeIndex(ep.Index) + " " + ep.IndexTitle)
+		if ep.Bvid != "" {
+			m.Bvid = ep.Bvid
+		}
+		if m.Cover == "" {
+			m.Cover = ep.Cover
+		}
+	}
+	if e.IsCompleted != nil {
+		m.Status = "completed"
+		if !*e.IsCompleted {
+			m.Status = "downloading"
+		}
+	}
+	return m
+}
+
+// episodeIndex 纯数字的集数补上"第N话"
+func episodeIndex(index string) string {
+	if _, err := strconv.Atoi(index); err == nil {
+		return "第" + index + "话"
+	}
+	return index
+}
+
+// ReadVideoMeta 按文件名读取PC、Android或UWP的元数据文件
+func ReadVideoMeta(path string) (*VideoMeta, error) {
+	if filepath.Ext(path) == UWPInfoSuffix {
+		m, err := readUWPMeta(path)
+		if err != nil {
+			return nil, err
+		}
+		m.ItemId = cmp.Or(m.ItemId, "0")
+		return m, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("找不到包含视频信息的info相关文件: %s", path)
+	}
+	var meta interface{ Meta() *VideoMeta }
+	switch filepath.Base(path) {
+	case EntryJson:
+		meta = &AndroidEntry{}
+	default:
+		meta = &PCVideoInfo{}
+	}
+	if err = json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("videoInfo相关文件解析失败: %s: %v", path, err)
+	}
+	m := meta.Meta()
+	m.ItemId = cmp.Or(m.ItemId, "0") // 与原来按整数读取时的结果一致
+	return m, nil
+}
+
+// ReadAndroidEntry 读取Android客户端的 entry.json
+func ReadAndroidEntry(path string) (*AndroidEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &AndroidEntry{}
+	if err = json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,177 @@
+package bilicache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIDUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want ID
+	}{
+		{`123`, "123"},
+		{`"456"`, "456"},
+		{`1190183512345678`, "1190183512345678"},
+		{`null`, ""},
+		{` "BV1xx" `, "BV1xx"},
+	}
+	for _, tt := range tests {
+		var id ID
+		if err := json.Unmarshal([]byte(tt.json), &id); err != nil {
+			t.Fatalf("%s: %v", tt.json, err)
+		}
+		if id != tt.want {
+			t.Errorf("%s 解析为 %q, 期望 %q", tt.json, id, tt.want)
+		}
+	}
+	var id ID
+	if err := json.Unmarshal([]byte(`true`), &id); err == nil {
+		t.Error("布尔值应返回错误")
+	}
+}
+
+func TestReadVideoMeta(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		want      VideoMeta
+		completed bool
+	}{
+		{
+			name: "PC videoInfo.json 数字ID",
+			path: "pc/" + VideoInfoJson,
+			want: VideoMeta{
+				GroupTitle: "【合集】测试合集",
+				Title:      "第一集 开场",
+				Uname:      "测试UP主",
+				Status:     "completed",
+				ItemId:     "1190183512",
+				GroupId:    "1234567890",
+				Uid:        "12345678",
+				Bvid:       "BV1Xx4y1m7Ab",
+				Cover:      "http://i0.hdslb.com/bfs/archive/cover.jpg",
+				CoverPath:  `D:\bilibili\1190183512\image.jpg`,
+				Uploader:   "测试UP主",
+				Page:       1,
+				PubDate:    1690000000,
+			},
+			completed: true,
+		},
+		{
+			name: "旧版PC .videoInfo 字符串ID",
+			path: "pc_old/" + VideoInfo,
+			want: VideoMeta{
+				GroupTitle: "旧版客户端",
+				Title:      "旧版标题",
+				Uname:      "旧版UP主",
+				Status:     "视频已缓存完成",
+				ItemId:     "987654",
+				GroupId:    "654321",
+				Uid:        "42",
+				Bvid:       "BV1ab411c7XY",
+				Cover:      "https://i0.hdslb.com/bfs/archive/old.jpg",
+				Uploader:   "旧版UP主",
+				Page:       3,
+			},
+			completed: true,
+		},
+		{
+			name: "PC videoInfo.json 没有UP主",
+			path: "pc_nouname/" + VideoInfoJson,
+			want: VideoMeta{
+				GroupTitle: "所有者",
+				Title:      "没有UP主的视频",
+				Uname:      "没有UP主的视频",
+				Status:     "视频已缓存完成",
+				ItemId:     "111",
+				GroupId:    "222",
+				Uid:        "0",
+				Bvid:       "BV1nn411c7Un",
+				Page:       1,
+			},
+			completed: true,
+		},
+		{
+			name: "Android普通视频",
+			path: "android_ugc/" + EntryJson,
+			want: VideoMeta{
+				GroupTitle: "安卓UP主",
+				Title:      "安卓视频标题 第二P",
+				Part:       "第二P",
+				Uname:      "安卓视频标题",
+				Status:     "completed",
+				ItemId:     "87654321",
+				GroupId:    "170001",
+				Uid:        "87654321",
+				Bvid:       "BV17x411w7KC",
+				Cid:        "279786",
+				Cover:      "http://i0.hdslb.com/bfs/archive/android.jpg",
+				Uploader:   "安卓UP主",
+				Page:       2,
+			},
+			completed: true,
+		},
+		{
+			name: "Android番剧未完成",
+			path: "android_bangumi/" + EntryJson,
+			want: VideoMeta{
+				GroupTitle: "测试番剧",
+				Title:      "测试番剧",
+				Uname:      "测试番剧",
+				Part:       "第12话 最终话",
+				Status:     "downloading",
+				ItemId:     "0",
+				GroupId:    "33378",
+				Uid:        "0",
+				Bvid:       "BV1GJ411x7h7",
+				Cid:        "900002",
+				Cover:      "http://i0.hdslb.com/bfs/bangumi/ep.jpg",
+				Page:       12,
+			},
+			completed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ReadVideoMeta(filepath.Join("testdata", tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*m, tt.want) {
+				t.Fatalf("解析结果为\n%+v\n期望\n%+v", *m, tt.want)
+			}
+			if m.Completed() != tt.completed {
+				t.Fatalf("Completed() 为 %v", m.Completed())
+			}
+		})
+	}
+}
+
+func TestReadVideoMetaError(t *testing.T) {
+	if _, err := ReadVideoMeta(filepath.Join("testdata", "missing", VideoInfoJson)); err == nil {
+		t.Error("文件不存在时应返回错误")
+	}
+	path := filepath.Join(t.TempDir(), EntryJson)
+	if err := os.WriteFile(path, []byte(`{"owner_id": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadVideoMeta(path); err == nil {
+		t.Error("ID类型错误时应返回错误")
+	}
+}
+
+func TestAndroidEntryBangumi(t *testing.T) {
+	for path, want := range map[string]bool{"android_ugc": false, "android_bangumi": true} {
+		e, err := ReadAndroidEntry(filepath.Join("testdata", path, EntryJson))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Bangumi() != want || e.MediaType != 2 {
+			t.Errorf("%s: Bangumi() 为 %v, media_type 为 %d", path, e.Bangumi(), e.MediaType)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bilicache
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// PlayUrl PC客户端的 .playurl，番剧的信息在result下，其它视频在data下
+type PlayUrl struct {
+	Data   *PlayUrlData `json:"data"`
+	Result *PlayUrlData `json:"result"`
+}
+
+// PlayUrlData .playurl 中的播放信息
+type PlayUrlData struct {
+	Quality    int   `json:"quality"`
+	Timelength int64 `json:"timelength"` // 视频时长，毫秒
+	Dash       *Dash `json:"dash"`
+}
+
+// Dash DASH格式的音视频流
+type Dash struct {
+	Duration int          `json:"duration"`
+	Video    []DashStream `json:"video"`
+	Audio    []DashStream `json:"audio"`
+	Flac     *struct {
+		Display bool        `json:"display"`
+		Audio   *DashStream `json:"audio"`
+	} `json:"flac"`
+	Dolby *struct {
+		Type  int          `json:"type"`
+		Audio []DashStream `json:"audio"`
+	} `json:"dolby"`
+}
+
+// DashStream 一个音频或视频流
+type DashStream struct {
+	ID        ID     `json:"id"`
+	Codecs    string `json:"codecs"`
+	CodecId   int    `json:"codecid"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	FrameRate string `json:"frameRate"`
+	Bandwidth int64  `json:"bandwidth"`
+}
+
+// FlacAudio 无损音频流，没有时返回nil
+func (d *Dash) FlacAudio() *DashStream {
+	if d.Flac == nil {
+		return nil
+	}
+	return d.Flac.Audio
+}
+
+// DolbyAudio 杜比音频流
+func (d *Dash) DolbyAudio() []DashStream {
+	if d.Dolby == nil {
+		return nil
+	}
+	return d.Dolby.Audio
+}
+
+// ReadPlayUrl 读取.playurl并返回其中的DASH信息
+func ReadPlayUrl(path string) (*Dash, error) {
+	data, err := ReadPlayUrlData(path)
+	if err != nil {
+		return nil, err
+	}
+	return data.Dash, nil
+}
+
+// ReadPlayUrlData 读取.playurl并返回包含DASH信息的播放信息
+func ReadPlayUrlData(path string) (*PlayUrlData, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p PlayUrl
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	data := p.Data
+	if data == nil || data.Dash == nil {
+		data = p.Result
+	}
+	if data == nil || data.Dash == nil {
+		return nil, errors.New("找不到音视频流信息")
+	}
+	return data, nil
+}
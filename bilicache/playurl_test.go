@@ -0,0 +1,61 @@
+package bilicache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPlayUrlData(t *testing.T) {
+	tests := []struct {
+		dir        string
+		quality    int
+		timelength int64
+		video      ID // 第一个视频流的id，数字和字符串两种写法
+		height     int
+		flac       ID // 无损音频流的id，没有时为空
+		dolby      int
+	}{
+		{dir: "playurl_data", quality: 80, timelength: 125400, video: "80", height: 1080, flac: "30251"},
+		{dir: "playurl_result", quality: 116, timelength: 1420000, video: "116", height: 1080, dolby: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			data, err := ReadPlayUrlData(filepath.Join("testdata", tt.dir, PlayUrlFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Quality != tt.quality || data.Timelength != tt.timelength {
+				t.Fatalf("quality=%d timelength=%d", data.Quality, data.Timelength)
+			}
+			d := data.Dash
+			if len(d.Video) != 1 || d.Video[0].ID != tt.video || d.Video[0].Height != tt.height || len(d.Audio) != 1 {
+				t.Fatalf("音视频流为 %+v %+v", d.Video, d.Audio)
+			}
+			var flac ID
+			if a := d.FlacAudio(); a != nil {
+				flac = a.ID
+			}
+			if flac != tt.flac || len(d.DolbyAudio()) != tt.dolby {
+				t.Fatalf("flac=%q dolby=%d", flac, len(d.DolbyAudio()))
+			}
+		})
+	}
+}
+
+func TestReadPlayUrlError(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":   `{"code":0,"data":null,"result":null}`,
+		"no-dash": `{"data":{"quality":80,"durl":[]}}`,
+		"invalid": `{"data":`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadPlayUrl(path); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package bilicache
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// UWPInfoSuffix Windows UWP（微软商店）客户端的视频信息文件扩展名
+const UWPInfoSuffix = ".info"
+
+/*
+	UWP客户端缓存目录结构：
+	<aid或season_id>/<aid>.info、cover.jpg  视频或剧集信息
+	<aid或season_id>/<分P>/<aid>_<分P>.info  分P信息
+	<aid或season_id>/<分P>/*.mp4、*.flv      音视频文件，头部有3个0xFF字节
+*/
+
+// UWPInfo UWP客户端的 .info 文件，字段名不区分大小写
+type UWPInfo struct {
+	Type       string `json:"Type"`
+	Title      string `json:"Title"`
+	Uploader   string `json:"Uploader"`
+	UploaderId ID     `json:"UploaderId"`
+	Aid        ID     `json:"Aid"`
+	Bid        string `json:"Bid"`
+	Cid        ID     `json:"Cid"`
+	SeasonId   ID     `json:"SeasonId"`
+	EpisodeId  ID     `json:"EpisodeId"`
+	PartNo     string `json:"PartNo"`
+	PartName   string `json:"PartName"`
+	CoverURL   string `json:"CoverURL"`
+}
+
+// Meta 转换为统一的视频信息，分P名称作为标题
+func (v *UWPInfo) Meta() *VideoMeta {
+	page, _ := strconv.Atoi(v.PartNo)
+	return &VideoMeta{
+		GroupTitle: v.Title,
+		Title:      cmp.Or(v.PartName, v.Title),
+		Uname:      v.Uploader,
+		ItemId:     v.Aid.String(),
+		GroupId:    cmp.Or(v.SeasonId, v.Aid).String(),
+		Uid:        v.UploaderId.String(),
+		Bvid:       v.Bid,
+		Cid:        v.Cid.String(),
+		Cover:      v.CoverURL,
+		Uploader:   v.Uploader,
+		Page:       page,
+	}
+}
+
+// ReadUWPInfo 读取UWP客户端的 .info 文件，UTF-8 BOM 会被忽略
+func ReadUWPInfo(path string) (*UWPInfo, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\ufeff"))
+	v := &UWPInfo{}
+	if err = json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("UWP视频信息文件解析失败: %s: %v", path, err)
+	}
+	return v, nil
+}
+
+// readUWPMeta 读取分P的 .info，合集名称和封面取自上一级目录的视频信息
+func readUWPMeta(path string) (*VideoMeta, error) {
+	v, err := ReadUWPInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	m := v.Meta()
+	parent := filepath.Dir(filepath.Dir(path))
+	if infos, _ := filepath.Glob(filepath.Join(parent, "*"+UWPInfoSuffix)); len(infos) > 0 {
+		if g, err := ReadUWPInfo(infos[0]); err == nil {
+			m.GroupTitle = cmp.Or(g.Title, m.GroupTitle)
+			m.Uname = cmp.Or(m.Uname, g.Uploader)
+			m.Uploader = cmp.Or(m.Uploader, g.Uploader)
+			m.Cover = cmp.Or(m.Cover, g.CoverURL)
+		}
+	}
+	m.CoverPath = filepath.Join(parent, "cover.jpg")
+	return m, nil
+}
@@ -0,0 +1,66 @@
+package bilicache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadUWPMeta(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 上一级目录的视频信息带有UTF-8 BOM
+	write("999/999.info", "\ufeff"+`{"Title":"UWP合集","Uploader":"UWP UP","CoverURL":"http://i0.hdslb.com/uwp.jpg"}`)
+	write("999/1/999_1.info", `{"Type":"UGC","Title":"分P标题","Aid":999,"Bid":"BV1uwp","Cid":"1001","PartNo":"1","PartName":"第一部分","UploaderId":3}`)
+	write("999/2/999_2.info", `{"Type":"UGC","Title":"分P标题","Aid":"999","Cid":1002,"PartNo":"2","Uploader":"分P的UP","CoverURL":"http://i0.hdslb.com/p2.jpg"}`)
+	write("ss100/1/100_1.info", `{"Type":"PGC","Title":"番剧","Aid":888,"SeasonId":100,"EpisodeId":5,"Cid":2001,"PartNo":"1","PartName":"第1话"}`)
+
+	tests := []struct {
+		path string
+		want VideoMeta
+	}{
+		{
+			path: "999/1/999_1.info",
+			want: VideoMeta{GroupTitle: "UWP合集", Title: "第一部分", Uname: "UWP UP", ItemId: "999", GroupId: "999", Uid: "3",
+				Bvid: "BV1uwp", Cid: "1001", Cover: "http://i0.hdslb.com/uwp.jpg", CoverPath: filepath.Join(root, "999", "cover.jpg"),
+				Uploader: "UWP UP", Page: 1},
+		},
+		{
+			// 分P自己的UP主和封面优先，没有分P名称时使用标题
+			path: "999/2/999_2.info",
+			want: VideoMeta{GroupTitle: "UWP合集", Title: "分P标题", Uname: "分P的UP", ItemId: "999", GroupId: "999",
+				Cid: "1002", Cover: "http://i0.hdslb.com/p2.jpg", CoverPath: filepath.Join(root, "999", "cover.jpg"),
+				Uploader: "分P的UP", Page: 2},
+		},
+		{
+			// 上一级目录没有视频信息时使用分P中的标题，番剧按season_id分组
+			path: "ss100/1/100_1.info",
+			want: VideoMeta{GroupTitle: "番剧", Title: "第1话", ItemId: "888", GroupId: "100", Cid: "2001",
+				CoverPath: filepath.Join(root, "ss100", "cover.jpg"), Page: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			m, err := ReadVideoMeta(filepath.Join(root, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*m, tt.want) {
+				t.Fatalf("解析结果为\n%+v\n期望\n%+v", *m, tt.want)
+			}
+			if !m.Completed() {
+				t.Fatal("UWP缓存应视为已完成")
+			}
+		})
+	}
+}
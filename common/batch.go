@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/sirupsen/logrus"
+)
+
+// interactive 标准输入是否为终端，不是终端时（计划任务、管道等）自动使用批处理模式
+func interactive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
+
+// termsFile 同意使用条款的记录文件
+func termsFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "m4s-converter", "terms-accepted"), nil
+}
+
+// termsAccepted 是否已有同意使用条款的记录
+func termsAccepted() bool {
+	file, err := termsFile()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(file)
+	return err == nil
+}
+
+// saveTermsAccepted 记录已同意使用条款，之后的批处理运行不再需要 --accept-terms
+func saveTermsAccepted() {
+	file, err := termsFile()
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(file), os.ModePerm); err == nil {
+			err = os.WriteFile(file, []byte(version+" "+time.Now().Format(time.RFC3339)+"\n"), 0644)
+		}
+	}
+	if err != nil {
+		logrus.Warn("保存同意使用条款的记录失败: ", err)
+	}
+}
+
+// alert 交互模式下弹出提示框，批处理模式下输出到标准错误
+func (c *Config) alert(text string) {
+	if c.Batch {
+		logrus.Error(text)
+		return
+	}
+	MessageBox(text)
+}
+
+// stderrHook 批处理模式下将错误日志同时输出到标准错误
+type stderrHook struct{}
+
+func (stderrHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (stderrHook) Fire(e *logrus.Entry) error {
+	_, err := fmt.Fprintln(os.Stderr, e.Message)
+	return err
+}
@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"m4s-converter/internal"
+	"m4s-converter/pipeline"
+	"m4s-converter/server"
 	"net/http"
 	"os"
 	"os/user"
@@ -29,25 +30,116 @@ func (c *Config) flag() {
 	flaggy.Bool(&c.Overlay, "o", "overlay", "合成文件时是否覆盖同名视频，默认不覆盖并重命名新文件")
 	flaggy.Bool(&c.Summarize, "u", "summarize", "将未合并的MP3和视频文件放入汇总目录，默认不汇总")
 	flaggy.String(&c.CachePath, "c", "cachepath", "自定义视频缓存路径，默认使用bilibili的默认缓存路径")
-	flaggy.String(&c.GPACPath, "g", "gpacpath", "自定义GPAC的mp4box文件路径,值为select时弹出选择对话框")
+	flaggy.String(&c.Format, "", "format", "输出格式: mp4(默认)、mkv(弹幕作为字幕轨道写入视频文件)")
+	flaggy.Bool(&c.Embed, "e", "embed", "MP4输出时将弹幕作为字幕轨道写入视频文件，不再生成单独的ass文件")
+	flaggy.Bool(&c.AudioOnly, "", "audio-only", "只导出音频文件(AAC和杜比音频为m4a,无损音频为flac)，不合成视频")
+	flaggy.Bool(&c.Audio, "", "with-audio", "合成视频的同时导出音频文件")
+	flaggy.String(&c.AudioPrefer, "", "audio-prefer", "缓存中有多个音频流时的选择策略: best(依次选择无损、杜比、AAC,默认)、flac、dolby、aac")
+	flaggy.String(&c.Prefer, "", "prefer", "缓存中有多个视频流时的选择策略,逗号分隔: hevc>avc>av1(按编码)、max-resolution、min-resolution、max-bitrate、smallest,默认max-resolution,max-bitrate")
+	flaggy.Int(&c.Jobs, "j", "jobs", "同时合成的条目数，默认1")
+	flaggy.Bool(&c.Watch, "w", "watch", "合成后继续监视缓存目录，新下载的视频缓存完成后自动合成，按Ctrl+C退出")
+	flaggy.Bool(&c.DryRun, "", "dry-run", "演练模式，只列出每个缓存目录选择的音视频流、输出路径及是否跳过，不写入任何文件")
+	flaggy.String(&c.Report, "", "report", "将每个条目的处理结果写入指定的JSON文件")
+	flaggy.String(&c.NameTemplate, "", "name-template", "输出路径模板(Go text/template语法,/分隔目录,不含扩展名),如 {{.Uploader}}/{{pad 2 .PageIndex}}-{{.Name}}，默认为 合集名称-UP主/分P名称")
+	flaggy.String(&c.VerifyFail, "", "on-verify-fail", "合成后校验轨道数、时长和音视频同步未通过时: keep(保留并在汇总中提示,默认)、delete(删除)、quarantine(移到输出目录下的"+pipeline.QuarantineDir+"目录)")
+	flaggy.String(&c.Backend, "b", "backend", "合成后端: native(内置封装器,默认)、mp4box、ffmpeg、auto(依次查找ffmpeg和MP4Box)")
+	flaggy.String(&c.GPACPath, "g", "gpacpath", "使用GPAC的mp4box合成并指定其文件路径,值为select时弹出选择对话框")
+	flaggy.String(&c.FFmpegPath, "f", "ffmpegpath", "自定义ffmpeg文件路径,默认在PATH中查找")
+	flaggy.Bool(&c.Batch, "", "batch", "批处理模式，不显示提示和对话框，错误输出到标准错误和退出码；标准输入不是终端时自动启用")
+	flaggy.Bool(&c.AcceptTerms, "", "accept-terms", "同意使用条款并记录，之后的批处理运行无需再次确认")
+	serve := flaggy.NewSubcommand("serve")
+	serve.Description = "启动本地HTTP服务，在网页中选择缓存条目合成并查看进度和输出文件"
+	serve.String(&c.Listen, "l", "listen", "监听地址，默认"+server.DefaultListen+"只允许本机访问，监听局域网地址(如0.0.0.0:8080)时必须设置--token")
+	serve.String(&c.Token, "t", "token", "访问令牌，设置后请求需要以Bearer令牌或Basic认证的密码(用户名任意)提供")
+	flaggy.AttachSubcommand(serve, 1)
 	flaggy.ShowHelpOnUnexpectedEnable() // 解析到未预期参数时显示帮助
 	flaggy.Parse()
+	c.Serve = serve.Used
 	if ver {
 		fmt.Println(color.CyanString("当前版本: %s", version))
 		fmt.Println(color.CyanString("编译信息: %s", buildTime))
 		fmt.Println(color.CyanString("源码版本: %s", sourceVer))
 		os.Exit(0)
 	}
+	if !c.Batch && !interactive() {
+		c.Batch = true
+	}
+	if c.Batch {
+		logrus.AddHook(stderrHook{})
+	}
 
-	if c.GPACPath != "" {
-		if c.GPACPath == "select" {
-			c.SelectGPACPath()
-			logrus.Warnln("使用MP4Box进行音视频合成")
+	switch c.Format {
+	case "":
+		c.Format = pipeline.FormatMP4
+	case pipeline.FormatMP4, pipeline.FormatMKV:
+	default:
+		logrus.Error("不支持的输出格式: ", c.Format)
+		os.Exit(1)
+	}
+	switch c.AudioPrefer {
+	case "":
+		c.AudioPrefer = pipeline.AudioPreferBest
+	case pipeline.AudioPreferBest, pipeline.AudioPreferFLAC, pipeline.AudioPreferDolby, pipeline.AudioPreferAAC:
+	default:
+		logrus.Error("不支持的音频选择策略: ", c.AudioPrefer)
+		os.Exit(1)
+	}
+	switch c.VerifyFail {
+	case "":
+		c.VerifyFail = pipeline.OnVerifyFailKeep
+	case pipeline.OnVerifyFailKeep, pipeline.OnVerifyFailDelete, pipeline.OnVerifyFailQuarantine:
+	default:
+		logrus.Error("不支持的校验失败处理方式: ", c.VerifyFail)
+		os.Exit(1)
+	}
+	if c.NameTemplate != "" {
+		t, err := pipeline.ParseNameTemplate(c.NameTemplate)
+		if err != nil {
+			logrus.Error(err)
+			os.Exit(1)
 		}
-		return
+		c.nameTemplate = t
+	}
+	if c.Listen == "" {
+		c.Listen = server.DefaultListen
+	}
+	if err := server.CheckListen(c.Listen, c.Token); c.Serve && err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+	if c.Jobs < 1 {
+		c.Jobs = 1
+	}
+	if c.Prefer == "" {
+		c.Prefer = pipeline.DefaultPrefer
 	}
-	c.GPACPath = internal.GetMP4Box()
-	logrus.Warnln("使用MP4Box进行音视频合成")
+	if _, e := pipeline.ParsePrefer(c.Prefer); e != nil {
+		logrus.Error(e)
+		os.Exit(1)
+	}
+	if c.GPACPath != "" && c.Backend == "" {
+		c.Backend = pipeline.BackendMP4Box
+	}
+	if c.GPACPath == "select" {
+		if c.Batch {
+			logrus.Error("批处理模式下不能弹出选择对话框，请指定MP4Box的路径")
+			os.Exit(ExitError)
+		}
+		c.SelectGPACPath()
+	}
+	m, e := pipeline.NewMuxer(pipeline.MuxerOptions{
+		Backend:    c.Backend,
+		GPACPath:   c.GPACPath,
+		FFmpegPath: c.FFmpegPath,
+		Format:     c.Format,
+		Overlay:    c.Overlay,
+	})
+	if e != nil {
+		logrus.Error(e)
+		os.Exit(1)
+	}
+	c.muxer = m
+	logrus.Warnf("使用%s进行音视频合成", c.muxer.Name())
 	if c.CachePath == "" {
 		if err != nil {
 			logrus.Warn("获取当前用户失败，使用默认缓存路径: ", err)
@@ -59,11 +151,23 @@ func (c *Config) flag() {
 	c.GetCachePath()
 }
 func (c *Config) InitConfig() {
-	go c.PanicHandler()
-
 	// 首先解析命令行参数
 	c.flag()
 
+	if c.AcceptTerms {
+		saveTermsAccepted()
+	}
+	if c.Batch {
+		// 批处理模式无法等待按键，需要通过参数或已保存的记录同意使用条款
+		if !c.AcceptTerms && !termsAccepted() {
+			logrus.Error("批处理模式需要先同意使用条款：仅转换本人通过哔哩哔哩官方客户端合法缓存的视频，且转换结果严格用于个人备份，绝不传播、分享或商用。同意请添加 --accept-terms 参数")
+			os.Exit(ExitError)
+		}
+		logrus.Info("已同意使用条款，程序继续执行")
+		c.diffVersion()
+		return
+	}
+
 	// 显示免责声明
 	fmt.Println("=====================================================")
 	fmt.Println("           使用本程序需遵守以下使用条款")
@@ -76,11 +180,12 @@ func (c *Config) InitConfig() {
 	// 等待用户输入任意键
 	_, _ = fmt.Scanln()
 	logrus.Info("用户同意使用，程序继续执行")
+	saveTermsAccepted()
 
-	diffVersion()
+	c.diffVersion()
 }
 
-func diffVersion() {
+func (c *Config) diffVersion() {
 	apiURL := "https://api.github.com/repos/mzky/m4s-converter/releases/latest"
 	// 创建带超时的HTTP客户端
 	client := &http.Client{
@@ -122,8 +227,10 @@ func diffVersion() {
 		if v.LessThan(lv) {
 			logrus.Warnln("发现新版本:", latestVersion, fmt.Sprintf("(当前版本:%s)", version))
 			logrus.Println("按住Ctrl并点击链接下载新版本:", releaseURL)
-			fmt.Print("按[回车]跳过更新...")
-			_, _ = fmt.Scanln()
+			if !c.Batch {
+				fmt.Print("按[回车]跳过更新...")
+				_, _ = fmt.Scanln()
+			}
 		}
 	}
 }
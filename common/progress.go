@@ -0,0 +1,149 @@
+package common
+
+import (
+	"fmt"
+	"m4s-converter/pipeline"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/sirupsen/logrus"
+)
+
+// progressBar 在终端最后一行显示合成进度，输出日志前清除进度条，输出后重新绘制
+type progressBar struct {
+	mu     sync.Mutex
+	line   string    // 当前显示的内容
+	hidden bool      // 正在输出日志
+	last   time.Time // 上次绘制的时间
+}
+
+// newProgressBar 标准输出是终端时创建进度条，否则返回nil
+func newProgressBar() *progressBar {
+	if !isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+		return nil
+	}
+	b := &progressBar{}
+	// 日志由golog的钩子写入标准输出，在其前后分别清除和重新绘制进度条
+	l := logrus.StandardLogger()
+	hooks := logrus.LevelHooks{}
+	for _, lv := range logrus.AllLevels {
+		hooks[lv] = append(append([]logrus.Hook{barHook{b, true}}, l.Hooks[lv]...), barHook{b, false})
+	}
+	l.ReplaceHooks(hooks)
+	return b
+}
+
+// Event 根据进度事件更新进度条
+func (b *progressBar) Event(e pipeline.Event) {
+	switch e := e.(type) {
+	case pipeline.CopyEvent:
+		b.set(fmt.Sprintf("修复 %s %s/%s", shorten(filepath.Base(e.File)), byteSize(e.Bytes), byteSize(e.Total)), false)
+	case pipeline.MuxEvent:
+		b.set(render(e.Progress)+fmt.Sprintf("  %s %.0f%%", shorten(filepath.Base(e.Output)), e.Percent), false)
+	case pipeline.ItemEvent:
+		b.set(render(e.Progress), true)
+	}
+}
+
+// Done 清除进度条，用于一次运行结束后打印汇总信息
+func (b *progressBar) Done() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		fmt.Print("\r\033[K")
+	}
+	b.line = ""
+}
+
+// set 更新进度条的内容，force为false时最多每100毫秒绘制一次
+func (b *progressBar) set(line string, force bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = line
+	if b.hidden || !force && time.Since(b.last) < 100*time.Millisecond {
+		return
+	}
+	b.draw()
+}
+
+// draw 绘制进度条，调用时需持有锁
+func (b *progressBar) draw() {
+	fmt.Print("\r\033[K" + b.line)
+	b.last = time.Now()
+}
+
+// barHook 在日志输出前(before)隐藏进度条，输出后重新绘制
+type barHook struct {
+	b      *progressBar
+	before bool
+}
+
+func (h barHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h barHook) Fire(*logrus.Entry) error {
+	h.b.mu.Lock()
+	defer h.b.mu.Unlock()
+	h.b.hidden = h.before
+	if h.b.line == "" {
+		return nil
+	}
+	if h.before {
+		fmt.Print("\r\033[K")
+	} else {
+		h.b.draw()
+	}
+	return nil
+}
+
+// render 整体进度，如 "[=========>          ] 45.2% 3/10 剩余 01:23"
+func render(p pipeline.Progress) string {
+	const width = 20
+	n := min(int(p.Percent/100*width), width)
+	bar := strings.Repeat("=", n)
+	if n < width {
+		bar += ">" + strings.Repeat(" ", width-n-1)
+	}
+	s := fmt.Sprintf("[%s] %5.1f%% %d/%d", bar, p.Percent, p.Done, p.Total)
+	if p.ETA > 0 {
+		s += " 剩余 " + clock(p.ETA)
+	}
+	return s
+}
+
+// clock 将时长格式化为 mm:ss 或 h:mm:ss
+func clock(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// byteSize 将字节数格式化为便于阅读的大小
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%dKB", n>>10)
+}
+
+// shorten 截断过长的文件名，避免进度条换行
+func shorten(name string) string {
+	r := []rune(name)
+	if len(r) <= 24 {
+		return name
+	}
+	return string(r[:23]) + "…"
+}
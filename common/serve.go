@@ -0,0 +1,34 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"m4s-converter/server"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartServer 启动HTTP服务，ctx取消时停止正在运行的任务并关闭服务后退出
+func (c *Config) StartServer(ctx context.Context) {
+	if !server.LocalOnly(c.Listen) {
+		logrus.Warn("监听地址", c.Listen, "不是本机地址，局域网中的设备可以使用访问令牌读取缓存和输出文件并启动合成，请勿在公共网络中使用")
+	}
+	s := server.New(ctx, c.Listen, c.Token, c.Pipeline)
+	srv := &http.Server{Addr: c.Listen, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	logrus.Info("HTTP服务已启动，请在浏览器中打开: http://", c.Listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.alert("启动HTTP服务失败: " + err.Error())
+		c.wait(ExitError)
+	}
+	logrus.Info("HTTP服务已关闭")
+	c.wait(ExitOK)
+}
@@ -1,294 +1,208 @@
 package common
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"m4s-converter/conver"
+	"m4s-converter/mp4"
+	"m4s-converter/pipeline"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"time"
 
-	"github.com/bitly/go-simplejson"
 	"github.com/fatih/color"
-	utils "github.com/mzky/utils/common"
 	"github.com/sirupsen/logrus"
 )
 
-func (c *Config) Synthesis() {
-	begin := time.Now().Unix()
-	logrus.Println("查找缓存目录下可转换的文件...")
-	// 查找m4s文件，并转换为mp4和mp3
-	if err := filepath.WalkDir(c.CachePath, c.FindM4sFiles); err != nil {
-		MessageBox(fmt.Sprintf("查找并转换 m4s 文件异常：%v", err))
-		c.wait()
+// Pipeline 根据命令行参数创建合成流程
+func (c *Config) Pipeline() *pipeline.Pipeline {
+	p := &pipeline.Pipeline{
+		Scanner:      &pipeline.Scanner{CachePath: c.CachePath, AssOFF: c.AssOFF, Audio: c.AudioPrefer, Video: c.Prefer, DryRun: c.DryRun, Jobs: c.Jobs},
+		Muxer:        c.muxer,
+		OutputDir:    c.OutputDir,
+		Format:       c.Format,
+		Embed:        c.Embed,
+		Audio:        c.Audio,
+		AudioOnly:    c.AudioOnly,
+		Summarize:    c.Summarize,
+		DryRun:       c.DryRun,
+		Jobs:         c.Jobs,
+		OnVerifyFail: c.VerifyFail,
+		NameTemplate: c.nameTemplate,
 	}
-
-	dirs, err := GetCacheDir(c.CachePath) // 缓存根目录模式
-	if err != nil {
-		MessageBox(fmt.Sprintf("找不到 BiliBili 的缓存目录：%v", err))
-		c.wait()
-	}
-
-	if dirs == nil {
-		// 判断非缓存根目录时，验证是否为子目录
-		if utils.IsExist(filepath.Join(c.CachePath, conver.VideoInfoSuffix)) ||
-			utils.IsExist(filepath.Join(c.CachePath, conver.VideoInfoJson)) {
-			dirs = append(dirs, c.CachePath)
-		}
+	if p.Muxer == nil {
+		p.Muxer = &pipeline.Native{}
 	}
+	return p
+}
 
-	// 合成音视频文件
-	c.OutputDir = filepath.Join(c.CachePath, "output")
-	var outputFiles []string
-	var skipFilePaths []string
-	for _, v := range dirs {
-		// 检查是否应该退出
-		if c.ShouldExit() {
-			logrus.Info("正在退出程序...")
-			break
-		}
-		video, audio, e := c.GetAudioAndVideo(v)
-		if e != nil {
-			logrus.Error("找不到已修复的音频和视频文件:", e)
-			continue
-		}
-		info := filepath.Join(v, conver.VideoInfoJson)
-		if !utils.IsExist(info) {
-			info = filepath.Join(v, conver.VideoInfoSuffix)
-			if !utils.IsExist(info) {
-				info = filepath.Join(v, conver.PlayEntryJson)
-				if !utils.IsExist(info) {
-					continue
-				}
-			}
-		}
-		infoStr, e := os.ReadFile(info)
-		if e != nil {
-			logrus.Error("找不到包含视频信息的info相关文件: ", info)
-			continue
-		}
-		js, e := simplejson.NewJson(infoStr)
-		if e != nil {
-			logrus.Error("videoInfo相关文件解析失败: ", info)
-			continue
-		}
-
-		groupTitle := Filter(js.Get("groupTitle").String())
-		groupTitle = null2Str(groupTitle, Filter(js.Get("owner_name").String()))
-
-		title := Filter(js.Get("page_data").Get("download_subtitle").String())
-		title = null2Str(title, Filter(js.Get("title").String()))
-
-		part := Filter(js.Get("page_data").Get("part").String())
-
-		uname := Filter(js.Get("uname").String())
-		uname = null2Str(uname, Filter(js.Get("title").String()))
-
-		status := Filter(js.Get("status").String())
-		status = null2Str(status, Filter(js.Get("page_data").Get("download_title").String()))
-
-		itemId, e := js.Get("itemId").Int()
-		if itemId == 0 || e != nil {
-			itemId, _ = js.Get("owner_id").Int()
-		}
-		c.ItemId = strconv.Itoa(itemId)
-
-		// 设置 groupId 和 uid
-		c.GroupId = Filter(js.Get("groupId").String())
-		c.Uid = Filter(js.Get("uid").String())
-
-		if status != "completed" && status != "视频已缓存完成" && status != "" {
-			skipFilePaths = append(skipFilePaths, v)
-			logrus.Warn("未缓存完成,跳过合成", v, title+"-"+uname)
-			continue
-		}
-		if !utils.IsExist(c.OutputDir) {
-			_ = os.MkdirAll(c.OutputDir, os.ModePerm)
-		}
-		groupPath := groupTitle + "-" + uname
-		groupDir := filepath.Join(c.OutputDir, groupPath)
-		if !utils.IsExist(groupDir) {
-			if err = os.MkdirAll(groupDir, os.ModePerm); err != nil {
-				MessageBox("无法创建目录：" + groupDir)
-				c.wait()
-			}
-		}
-		// 生成输出文件名
-		// mp4Name := title + "-" + part + conver.Mp4Suffix
-		if part == "" {
-			part = title
-		}
-		mp4Name := part + conver.Mp4Suffix
-		outputFile := filepath.Join(groupDir, mp4Name)
-
-		// 设置元数据信息
-		c.Title = title
-		c.Uname = uname
-		c.GroupTitle = groupTitle
-
-		// 检查是否已经存在已合并文件
-		if utils.IsExist(outputFile) {
-			// 提取已合并文件的元数据
-			metadata, getErr := c.getMp4Metadata(outputFile)
-			if getErr == nil {
-				// 验证三个值是否一致
-				if metadata["title"] == c.GroupId && metadata["artist"] == c.Uid && metadata["album"] == c.ItemId {
-					logrus.Warn("跳过已合并文件: ", outputFile)
-					continue
-				}
-			}
-			// 如果元数据提取失败或验证失败，仍然跳过同名文件
-			logrus.Warn("跳过已合并的视频: ", outputFile)
-			continue
-		}
+// 退出码，便于脚本判断运行结果
+const (
+	ExitOK       = 0   // 全部成功，或没有需要合成的条目
+	ExitError    = 1   // 参数错误或运行异常
+	ExitFailed   = 2   // 部分条目合成失败
+	ExitNotFound = 3   // 没有找到可转换的缓存
+	ExitCanceled = 130 // 被Ctrl+C中断
+)
 
-		// 检查目录中是否存在与输入音频和视频文件内容相同的文件
-		if exists, existingFile := c.isIdenticalFileExists(groupDir, video, audio, part); exists {
-			logrus.Warn("跳过完全相同的视频: ", existingFile)
-			continue
-		}
+// exitCode 根据运行结果返回退出码
+func exitCode(res *pipeline.Result, err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ExitCanceled
+	case err != nil:
+		return ExitError
+	case len(res.Items) == 0:
+		return ExitNotFound
+	case len(res.Filter(pipeline.StatusFailed)) > 0:
+		return ExitFailed
+	}
+	return ExitOK
+}
 
-		// 执行合成
-		if er := c.Composition(video, audio, outputFile); er != nil {
-			logrus.Errorf("%s 合成失败", filepath.Base(outputFile))
-			continue
+// Synthesis 执行合成任务并打印汇总信息，监视模式下继续合成新下载完成的视频
+func (c *Config) Synthesis(ctx context.Context) {
+	p := c.Pipeline()
+	var bar *progressBar
+	if !c.Batch && !c.DryRun {
+		if bar = newProgressBar(); bar != nil {
+			p.OnEvent = bar.Event
 		}
+	}
+	res, err := p.Run(ctx)
+	bar.Done()
+	c.OutputDir = res.OutputDir
+	c.writeReport(res, err)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.alert(err.Error())
+		c.wait(ExitError)
+	}
+	if c.DryRun {
+		c.printPlan(res)
+		c.wait(exitCode(res, err))
+	}
+	c.summary(res)
+	if !c.Watch || ctx.Err() != nil {
+		c.wait(exitCode(res, err))
+	}
 
-		// 生成并存储文件哈希值，用于后续的重复检测
-		hashFile := strings.ReplaceAll(outputFile, ".mp4", ".hash")
-		inputHash := c.calculateCombinedHash(video, audio)
-		if inputHash != "" {
-			_ = os.WriteFile(hashFile, []byte(inputHash), 0644)
+	err = p.Watch(ctx, pipeline.DefaultWatchDelay, func(res *pipeline.Result, err error) {
+		bar.Done()
+		c.writeReport(res, err)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logrus.Error(err)
+			return
 		}
-
-		outputFiles = append(outputFiles, filepath.Join(groupPath, mp4Name))
+		c.summary(res)
+	})
+	if err != nil {
+		c.alert("监视缓存目录失败: " + err.Error())
+		c.wait(ExitError)
 	}
+	logrus.Info("已停止监视缓存目录")
+	c.wait(ExitOK)
+}
 
-	// 处理未合并的MP3和视频文件
-	if c.Summarize {
-		// 查找未合并的MP3和视频文件
-		for _, v := range dirs {
-			video, audio, e := c.GetAudioAndVideo(v)
-			if e != nil {
-				continue
-			}
-
-			// 检查是否已经合成
-			info := filepath.Join(v, conver.VideoInfoJson)
-			if !utils.IsExist(info) {
-				info = filepath.Join(v, conver.VideoInfoSuffix)
-				if !utils.IsExist(info) {
-					info = filepath.Join(v, conver.PlayEntryJson)
-					if !utils.IsExist(info) {
-						continue
-					}
-				}
-			}
-			infoStr, e := os.ReadFile(info)
-			if e != nil {
-				continue
-			}
-			js, e := simplejson.NewJson(infoStr)
-			if e != nil {
-				continue
-			}
-
-			groupTitle := Filter(js.Get("groupTitle").String())
-			groupTitle = null2Str(groupTitle, Filter(js.Get("owner_name").String()))
-			uname := Filter(js.Get("uname").String())
-			uname = null2Str(uname, Filter(js.Get("title").String()))
-			title := Filter(js.Get("page_data").Get("download_subtitle").String())
-			title = null2Str(title, Filter(js.Get("title").String()))
-
-			// 添加空值检查，避免创建空目录名或尝试复制空文件路径
-			if groupTitle == "" && uname == "" {
-				logrus.Warn("项目信息为空，跳过处理未合并文件: ", v)
-				continue
-			}
-
-			// 创建项目特定的未合并文件夹
-			groupPath := groupTitle + "-" + uname
-			groupDir := filepath.Join(c.OutputDir, groupPath)
-			if !utils.IsExist(groupDir) {
-				if err = os.MkdirAll(groupDir, os.ModePerm); err != nil {
-					logrus.Error("创建项目目录失败: ", err)
-					continue
-				}
-			}
-			summaryDir := filepath.Join(groupDir, "未合并文件")
-			if !utils.IsExist(summaryDir) {
-				if err = os.MkdirAll(summaryDir, os.ModePerm); err != nil {
-					logrus.Error("创建未合并文件目录失败: ", err)
-					continue
-				}
-			}
-
-			// 复制未合并的视频文件
-			if utils.IsExist(video) && title != "" {
-				videoDest := filepath.Join(summaryDir, title+"_video"+filepath.Ext(video))
-				if !utils.IsExist(videoDest) {
-					if err := c.copyFile(video, videoDest); err == nil {
-						logrus.Info("已将未合并的视频文件放入汇总目录: ", videoDest)
-					}
-				} else {
-					logrus.Warn("未合并的视频文件已存在，跳过复制: ", videoDest)
-				}
-			}
+// writeReport 指定了 --report 时写入运行报告
+func (c *Config) writeReport(res *pipeline.Result, err error) {
+	if c.Report == "" {
+		return
+	}
+	if e := res.WriteReport(c.Report, err); e != nil {
+		logrus.Error("写入运行报告失败: ", e)
+	} else {
+		logrus.Info("已写入运行报告: ", c.Report)
+	}
+}
 
-			// 复制未合并的音频文件
-			if utils.IsExist(audio) && title != "" {
-				audioDest := filepath.Join(summaryDir, title+"_audio"+filepath.Ext(audio))
-				if !utils.IsExist(audioDest) {
-					if err := c.copyFile(audio, audioDest); err == nil {
-						logrus.Info("已将未合并的音频文件放入汇总目录: ", audioDest)
-					}
-				} else {
-					logrus.Warn("未合并的音频文件已存在，跳过复制: ", audioDest)
-				}
-			}
+// summary 打印合成和跳过的文件
+func (c *Config) summary(res *pipeline.Result) {
+	var outputFiles []string
+	for _, v := range res.Filter(pipeline.StatusConverted) {
+		rel, _ := filepath.Rel(res.OutputDir, v.Output)
+		outputFiles = append(outputFiles, rel)
+	}
+	var skipFilePaths []string
+	skipped := map[string]bool{}
+	for _, v := range res.Filter(pipeline.StatusSkipped) {
+		// 同时导出音频时同一目录会有两条结果
+		if !v.Item.Completed() && !skipped[v.Item.Dir] {
+			skipped[v.Item.Dir] = true
+			skipFilePaths = append(skipFilePaths, v.Item.Dir)
 		}
 	}
 
-	end := time.Now().Unix()
 	logrus.Print("===========================================")
 	if skipFilePaths != nil {
 		logrus.Print("跳过的目录:\n" + strings.Join(skipFilePaths, "\n"))
 	}
 	if outputFiles != nil {
-		logrus.Printf("# 输出目录:\n%s", color.CyanString(c.OutputDir))
+		logrus.Printf("# 输出目录:\n%s", color.CyanString(res.OutputDir))
 		logrus.Printf("# 合成的文件:\n%s", color.CyanString(strings.Join(outputFiles, "\n")))
-		// 打开合成文件目录
-		go OpenFolder(c.OutputDir)
+		// 打开合成文件目录，监视模式下不反复打开
+		if !c.Batch && !c.Watch {
+			go OpenFolder(res.OutputDir)
+		}
 	} else {
 		logrus.Warn("未合成任何文件！")
 	}
+	for _, v := range res.Items {
+		if !v.Verify.OK() {
+			rel, _ := filepath.Rel(res.OutputDir, v.Output)
+			logrus.Warnf("%s %s: %s", color.YellowString("[校验未通过]"), rel, strings.Join(v.Verify.Problems, "；"))
+		}
+	}
 	logrus.Print("===========================================")
-	logrus.Print("已完成合成任务，耗时: ", end-begin, "秒")
-	c.wait()
+	if failed := res.Filter(pipeline.StatusFailed); failed != nil {
+		logrus.Errorf("%d个条目合成失败", len(failed))
+	}
+	logrus.Print("已完成合成任务，耗时: ", res.End.Unix()-res.Begin.Unix(), "秒")
 }
 
-func (c *Config) findMp4Info(fp, sub string) bool {
-	if !utils.IsExist(c.GPACPath) {
-		return false
+// printPlan 打印演练模式下每个条目的处理计划
+func (c *Config) printPlan(res *pipeline.Result) {
+	logrus.Print("===========================================")
+	logrus.Printf("# 输出目录:\n%s", color.CyanString(res.OutputDir))
+	planned := 0
+	for _, v := range res.Items {
+		rel, _ := filepath.Rel(res.OutputDir, v.Output)
+		if v.Output == "" {
+			rel = v.Item.Name()
+		}
+		switch v.Status {
+		case pipeline.StatusPlanned:
+			planned++
+			logrus.Printf("%s %s", color.GreenString("[合成]"), color.CyanString(rel))
+		case pipeline.StatusSkipped:
+			logrus.Printf("%s %s (%s)", color.YellowString("[跳过]"), rel, v.Reason)
+		default:
+			logrus.Printf("%s %s: %v", color.RedString("[失败]"), rel, v.Err)
+		}
+		logrus.Print("  缓存目录: ", v.Item.Dir)
+		if v.Item.Video != "" {
+			video, _ := filepath.Rel(v.Item.Dir, v.Item.Video)
+			audio, _ := filepath.Rel(v.Item.Dir, v.Item.Audio)
+			logrus.Printf("  视频: %s 音频: %s", video, audio)
+		}
 	}
-	ret, err := exec.Command(c.GPACPath, "-info", fp).CombinedOutput()
+	logrus.Print("===========================================")
+	logrus.Printf("演练完成，共%d项，将处理%d项，未写入任何文件", len(res.Items), planned)
+}
+
+func (c *Config) findMp4Info(fp, sub string) bool {
+	tags, err := mp4.ReadTags(fp)
 	if err != nil {
 		return false
 	}
-	return strings.Contains(string(ret), sub)
+	return strings.Contains(tags.String(), sub)
 }
 
-func null2Str(s string, value string) string {
-	if s != "" {
-		return s
+// wait 等待按键后以指定的退出码退出，批处理模式下直接退出
+func (c *Config) wait(code int) {
+	if c.Batch {
+		os.Exit(code)
 	}
-	return value
-}
-
-func (c *Config) wait() {
 	fmt.Println("按任意键退出程序")
 	_, _ = fmt.Scanln()
-	os.Exit(0)
+	os.Exit(code)
 }
@@ -0,0 +1,167 @@
+package conver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEventFormat 缺少Format行时使用的事件字段顺序
+var defaultEventFormat = []string{"Layer", "Start", "End", "Style", "Name", "MarginL", "MarginR", "MarginV", "Effect", "Text"}
+
+// AssEvent ASS字幕中的一条Dialogue
+type AssEvent struct {
+	ReadOrder int // 在原文件中的顺序
+	Start     time.Duration
+	End       time.Duration
+	Layer     string
+	Style     string
+	Name      string
+	MarginL   string
+	MarginR   string
+	MarginV   string
+	Effect    string
+	Text      string
+}
+
+// Ass 解析后的ASS字幕
+type Ass struct {
+	Header string // [Events]之外的所有段落（含[Fonts]等），以及[Events]段的Format行
+	Events []AssEvent
+}
+
+// ParseAss 读取ASS文件，事件按开始时间排序
+func ParseAss(path string) (*Ass, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var header strings.Builder
+	ass := &Ass{}
+	format := defaultEventFormat
+	section := ""
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if section == "" {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.ToLower(trimmed)
+			if section != "[events]" {
+				header.WriteString(line + "\n")
+			}
+			continue
+		}
+		if section != "[events]" {
+			header.WriteString(line + "\n")
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Format":
+			format = nil
+			for _, v := range strings.Split(value, ",") {
+				format = append(format, strings.TrimSpace(v))
+			}
+		case "Dialogue":
+			e, err := parseAssEvent(strings.TrimLeft(value, " "), format)
+			if err != nil {
+				return nil, err
+			}
+			e.ReadOrder = len(ass.Events)
+			ass.Events = append(ass.Events, e)
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	ass.Header = strings.TrimRight(header.String(), "\n") + "\n\n[Events]\nFormat: " + strings.Join(format, ", ") + "\n"
+	sort.SliceStable(ass.Events, func(i, j int) bool {
+		return ass.Events[i].Start < ass.Events[j].Start
+	})
+	return ass, nil
+}
+
+func parseAssEvent(value string, format []string) (AssEvent, error) {
+	var e AssEvent
+	fields := strings.SplitN(value, ",", len(format))
+	if len(fields) != len(format) {
+		return e, fmt.Errorf("ASS事件字段数量不正确: %s", value)
+	}
+	var err error
+	for i, name := range format {
+		v := fields[i]
+		switch name {
+		case "Start":
+			e.Start, err = parseAssTime(v)
+		case "End":
+			e.End, err = parseAssTime(v)
+		case "Layer":
+			e.Layer = v
+		case "Style":
+			e.Style = v
+		case "Name", "Actor":
+			e.Name = v
+		case "MarginL":
+			e.MarginL = v
+		case "MarginR":
+			e.MarginR = v
+		case "MarginV":
+			e.MarginV = v
+		case "Effect":
+			e.Effect = v
+		case "Text":
+			e.Text = v
+		}
+		if err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// parseAssTime 解析 H:MM:SS.cc 格式的时间
+func parseAssTime(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("ASS时间格式不正确: %s", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("ASS时间格式不正确: %s", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*1000)*time.Millisecond, nil
+}
+
+// MatroskaData 返回Matroska中S_TEXT/ASS块的内容，去掉了开始和结束时间
+func (e AssEvent) MatroskaData() string {
+	return strings.Join([]string{strconv.Itoa(e.ReadOrder), e.Layer, e.Style, e.Name,
+		e.MarginL, e.MarginR, e.MarginV, e.Effect, e.Text}, ",")
+}
+
+var assOverride = regexp.MustCompile(`\{[^}]*\}`)
+
+// PlainText 去掉样式标签后的纯文本
+func (e AssEvent) PlainText() string {
+	text := assOverride.ReplaceAllString(e.Text, "")
+	text = strings.ReplaceAll(text, `\N`, "\n")
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	text = strings.ReplaceAll(text, `\h`, " ")
+	return strings.TrimSpace(text)
+}
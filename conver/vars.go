@@ -4,7 +4,9 @@ var (
 	AssSuffix         = ".ass"
 	XmlSuffix         = ".xml"
 	M4sSuffix         = ".m4s"
+	BlvSuffix         = ".blv" // 旧版安卓手机端的分段flv
 	Mp4Suffix         = ".mp4"
+	MkvSuffix         = ".mkv"
 	VideoInfoSuffix   = ".videoInfo"
 	VideoInfoJson     = "videoInfo.json"
 	AudioSuffix       = "-audio.mp3"
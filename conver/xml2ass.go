@@ -2,6 +2,7 @@ package conver
 
 import (
 	"fmt"
+	"m4s-converter/internal"
 	"os"
 	"path/filepath"
 	"strings"
@@ -45,33 +46,26 @@ func Xml2Ass(xml string) string {
 			continue
 		}
 
-		dstFile = strings.ReplaceAll(file, filepath.Ext(file), AssSuffix)
-		dst, e := os.Create(dstFile)
-		if e != nil {
-			logrus.Warnf("无法创建ASS文件：%v", e)
-			failed++
-			_ = src.Close()
-			continue
-		}
-
-		// 添加panic恢复机制，防止XML文件格式错误导致软件崩溃
-		func() {
+		ass := strings.ReplaceAll(file, filepath.Ext(file), AssSuffix)
+		// 先写入临时文件，转换成功后再重命名，避免留下不完整的字幕
+		e := internal.WriteFile(ass, func(dst *os.File) (err error) {
+			// 添加panic恢复机制，防止XML文件格式错误导致软件崩溃
 			defer func() {
 				if r := recover(); r != nil {
-					logrus.Warnf("处理XML文件时发生错误：%v，跳过生成字幕", r)
-					failed++
+					err = fmt.Errorf("处理XML文件时发生错误：%v", r)
 				}
-				_ = src.Close()
-				_ = dst.Close()
 			}()
-
 			// 如果在go程中加载xml，当文件过多时会出现过高的内存占用
 			pool := converter.LoadPool(src, chain)
-			if er := pool.Convert(dst, assConfig); er != nil {
-				logrus.Warnf("转换XML到ASS失败：%v", er)
-				failed++
-			}
-		}()
+			return pool.Convert(dst, assConfig)
+		})
+		_ = src.Close()
+		if e != nil {
+			logrus.Warnf("转换XML到ASS失败：%v，跳过生成字幕", e)
+			failed++
+			continue
+		}
+		dstFile = ass
 	}
 	// fmt.Println("转换弹幕:", "成功数", len(xmls)-failed, "失败数", failed)
 	return dstFile
@@ -0,0 +1,289 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"m4s-converter/mp4"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 元数据块类型
+const (
+	blockStreamInfo    = 0
+	blockPadding       = 1
+	blockVorbisComment = 4
+	blockPicture       = 6
+)
+
+// vendor 写入Vorbis注释的编码器名称
+const vendor = "m4s-converter"
+
+// tagNames Vorbis注释字段与mp4.Tags字段的对应关系
+var tagNames = []struct {
+	name  string
+	field func(*mp4.Tags) *string
+}{
+	{"TITLE", func(t *mp4.Tags) *string { return &t.Title }},
+	{"ARTIST", func(t *mp4.Tags) *string { return &t.Artist }},
+	{"ALBUM", func(t *mp4.Tags) *string { return &t.Album }},
+	{"COMMENT", func(t *mp4.Tags) *string { return &t.Comment }},
+	{"DATE", func(t *mp4.Tags) *string { return &t.Date }},
+	{"COPYRIGHT", func(t *mp4.Tags) *string { return &t.Copyright }},
+}
+
+// metadataBlock FLAC元数据块
+type metadataBlock struct {
+	typ  byte
+	data []byte
+}
+
+// WriteFile 将MP4中的FLAC轨道写为.flac文件，同时写入Vorbis注释和封面
+func WriteFile(name string, t *mp4.Track, tags mp4.Tags) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = Write(f, t, tags); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write 写入fLaC标记、元数据块和音频帧，MP4中的每个样本就是一个完整的FLAC帧
+func Write(w io.Writer, t *mp4.Track, tags mp4.Tags) error {
+	if t.Codec != "fLaC" {
+		return errors.New("不是FLAC音频轨道: " + t.Codec)
+	}
+	dfLa := t.CodecBox("dfLa")
+	if len(dfLa) < 4 {
+		return errors.New("找不到FLAC的dfLa盒子")
+	}
+	blocks, err := parseBlocks(dfLa[4:])
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 || blocks[0].typ != blockStreamInfo || len(blocks[0].data) < 34 {
+		return errors.New("FLAC缺少STREAMINFO")
+	}
+	fillTotalSamples(blocks[0].data, t)
+
+	// 去掉原有的注释、封面和填充，使用条目的元数据
+	var out []metadataBlock
+	for _, b := range blocks {
+		switch b.typ {
+		case blockVorbisComment, blockPicture, blockPadding:
+			continue
+		}
+		out = append(out, b)
+	}
+	out = append(out, metadataBlock{blockVorbisComment, vorbisComment(tags)})
+	if len(tags.Cover) > 0 {
+		out = append(out, metadataBlock{blockPicture, picture(tags.Cover)})
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if _, err = bw.WriteString("fLaC"); err != nil {
+		return err
+	}
+	for i, b := range out {
+		h := uint32(b.typ)<<24 | uint32(len(b.data))
+		if i == len(out)-1 {
+			h |= 0x80 << 24
+		}
+		if err = binary.Write(bw, binary.BigEndian, h); err != nil {
+			return err
+		}
+		if _, err = bw.Write(b.data); err != nil {
+			return err
+		}
+	}
+	for _, s := range t.Samples {
+		if _, err = io.Copy(bw, io.NewSectionReader(t.Source, s.Offset, int64(s.Size))); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// parseBlocks 解析连续的元数据块，遇到最后一个块时结束
+func parseBlocks(b []byte) ([]metadataBlock, error) {
+	var blocks []metadataBlock
+	for len(b) >= 4 {
+		last, typ := b[0]&0x80 != 0, b[0]&0x7F
+		n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		if 4+n > len(b) {
+			return nil, errors.New("FLAC元数据块长度不正确")
+		}
+		blocks = append(blocks, metadataBlock{typ, bytes.Clone(b[4 : 4+n])})
+		b = b[4+n:]
+		if last {
+			break
+		}
+	}
+	return blocks, nil
+}
+
+// fillTotalSamples 分片文件的STREAMINFO中总采样数通常为0，按轨道时长补上
+func fillTotalSamples(info []byte, t *mp4.Track) {
+	v := binary.BigEndian.Uint64(info[10:])
+	const mask = 1<<36 - 1
+	if v&mask != 0 || uint32(v>>44) != t.Timescale {
+		return
+	}
+	binary.BigEndian.PutUint64(info[10:], v|t.Duration()&mask)
+}
+
+func vorbisComment(tags mp4.Tags) []byte {
+	var comments []string
+	for _, v := range tagNames {
+		if value := *v.field(&tags); value != "" {
+			comments = append(comments, v.name+"="+value)
+		}
+	}
+	keys := make([]string, 0, len(tags.Custom))
+	for k := range tags.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		comments = append(comments, k+"="+tags.Custom[k])
+	}
+
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// picture 生成封面图片块，类型为3（封面）
+func picture(cover []byte) []byte {
+	mime := "image/jpeg"
+	if bytes.HasPrefix(cover, []byte("\x89PNG")) {
+		mime = "image/png"
+	}
+	var b []byte
+	b = binary.BigEndian.AppendUint32(b, 3)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(mime)))
+	b = append(b, mime...)
+	b = binary.BigEndian.AppendUint32(b, 0) // 描述
+	b = append(b, make([]byte, 16)...)      // 宽、高、色深、颜色数，未知时为0
+	b = binary.BigEndian.AppendUint32(b, uint32(len(cover)))
+	return append(b, cover...)
+}
+
+// ReadTags 读取.flac文件的Vorbis注释和封面
+func ReadTags(name string) (mp4.Tags, error) {
+	var tags mp4.Tags
+	f, err := os.Open(name)
+	if err != nil {
+		return tags, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return tags, errors.New("不是FLAC文件")
+	}
+	for {
+		var h uint32
+		if err = binary.Read(r, binary.BigEndian, &h); err != nil {
+			return tags, err
+		}
+		data := make([]byte, h&0xFFFFFF)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return tags, err
+		}
+		switch byte(h>>24) & 0x7F {
+		case blockVorbisComment:
+			parseVorbisComment(data, &tags)
+		case blockPicture:
+			tags.Cover = parsePicture(data)
+		}
+		if h&(0x80<<24) != 0 {
+			return tags, nil
+		}
+	}
+}
+
+func parseVorbisComment(b []byte, tags *mp4.Tags) {
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	if _, ok := next(); !ok || len(b) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for ; count > 0; count-- {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		k, v, found := strings.Cut(c, "=")
+		if !found {
+			continue
+		}
+		setTag(tags, k, v)
+	}
+}
+
+func setTag(tags *mp4.Tags, name, value string) {
+	for _, v := range tagNames {
+		if strings.EqualFold(v.name, name) {
+			*v.field(tags) = value
+			return
+		}
+	}
+	if tags.Custom == nil {
+		tags.Custom = map[string]string{}
+	}
+	tags.Custom[name] = value
+}
+
+func parsePicture(b []byte) []byte {
+	rd := bytes.NewReader(b)
+	var typ, n uint32
+	skip := func() bool {
+		if binary.Read(rd, binary.BigEndian, &n) != nil {
+			return false
+		}
+		_, err := rd.Seek(int64(n), io.SeekCurrent)
+		return err == nil
+	}
+	if binary.Read(rd, binary.BigEndian, &typ) != nil || !skip() || !skip() {
+		return nil
+	}
+	if _, err := rd.Seek(16, io.SeekCurrent); err != nil {
+		return nil
+	}
+	if binary.Read(rd, binary.BigEndian, &n) != nil || int(n) > rd.Len() {
+		return nil
+	}
+	data := make([]byte, n)
+	_, _ = io.ReadFull(rd, data)
+	return data
+}
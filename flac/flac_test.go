@@ -0,0 +1,148 @@
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// mkbox 生成MP4盒子
+func mkbox(typ string, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(p))), typ...), p...)
+}
+
+// block 生成元数据块
+func block(typ byte, last bool, data []byte) []byte {
+	if last {
+		typ |= 0x80
+	}
+	return append([]byte{typ, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// testTrack 44100Hz双声道的FLAC轨道，n个帧，每帧4096个采样。
+// dfLa中除了STREAMINFO还有原有的注释和填充，写入时应被替换
+func testTrack(n int) *mp4.Track {
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info, 4096)
+	binary.BigEndian.PutUint16(info[2:], 4096)
+	binary.BigEndian.PutUint64(info[10:], 44100<<44|1<<41|15<<36) // 总采样数为0
+	dfLa := bytes.Join([][]byte{
+		{0, 0, 0, 0},
+		block(blockStreamInfo, false, info),
+		block(blockVorbisComment, false, vorbisComment(mp4.Tags{Title: "原有标题"})),
+		block(blockPadding, true, make([]byte, 16)),
+	}, nil)
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint32(entry[24:], 44100<<16)
+	t := &mp4.Track{
+		Handler:     mp4.Audio,
+		Codec:       "fLaC",
+		Timescale:   44100,
+		SampleEntry: mkbox("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, mkbox("fLaC", entry, mkbox("dfLa", dfLa))),
+		Source:      bytes.NewReader(bytes.Repeat([]byte{0xFF, 0xF8, 0x69, 0x18}, n)),
+	}
+	for i := 0; i < n; i++ {
+		t.Samples = append(t.Samples, mp4.Sample{Offset: int64(i * 4), Size: 4, Duration: 4096, Sync: true})
+	}
+	return t
+}
+
+func TestWriteReadTags(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{7}, 100)...)
+	tests := []struct {
+		name string
+		tags mp4.Tags
+		mime string
+	}{
+		{
+			name: "注释和封面",
+			tags: mp4.Tags{Title: "标题=带等号", Artist: "UP主", Album: "合集", Comment: "简介", Date: "2024", Copyright: "bilibili",
+				Cover: []byte{0xFF, 0xD8, 0xFF, 0xE0, 1, 2, 3}, Custom: map[string]string{"BVID": "BV1xx411c7mD", "PART": "分P"}},
+			mime: "image/jpeg",
+		},
+		{name: "PNG封面", tags: mp4.Tags{Title: "标题", Cover: png}, mime: "image/png"},
+		{name: "没有封面", tags: mp4.Tags{Artist: "UP主"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "a.flac")
+			track := testTrack(3)
+			if err := WriteFile(name, track, tt.tags); err != nil {
+				t.Fatal(err)
+			}
+			tags, err := ReadTags(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Fatalf("读取的元数据为\n%+v\n期望\n%+v", tags, tt.tags)
+			}
+
+			b, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b[:4]) != "fLaC" {
+				t.Fatalf("文件开头为 %q", b[:4])
+			}
+			blocks, err := parseBlocks(b[4:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var types []byte
+			size := 4
+			for _, bl := range blocks {
+				types = append(types, bl.typ)
+				size += 4 + len(bl.data)
+			}
+			want := []byte{blockStreamInfo, blockVorbisComment}
+			if tt.mime != "" {
+				want = append(want, blockPicture)
+				if pic := blocks[2].data; string(pic[8:8+len(tt.mime)]) != tt.mime {
+					t.Fatalf("封面类型为 %q", pic[8:8+len(tt.mime)])
+				}
+			}
+			if !bytes.Equal(types, want) {
+				t.Fatalf("元数据块为 %v, 期望 %v", types, want)
+			}
+			// 总采样数按轨道时长补上
+			if total := binary.BigEndian.Uint64(blocks[0].data[10:]) & (1<<36 - 1); total != 3*4096 {
+				t.Fatalf("总采样数为 %d", total)
+			}
+			if frames := b[size:]; !bytes.Equal(frames, bytes.Repeat([]byte{0xFF, 0xF8, 0x69, 0x18}, 3)) {
+				t.Fatalf("音频帧为 %x", frames)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	track := testTrack(1)
+	track.Codec = "mp4a"
+	if err := Write(&bytes.Buffer{}, track, mp4.Tags{}); err == nil {
+		t.Error("不是FLAC轨道时应返回错误")
+	}
+	name := filepath.Join(t.TempDir(), "a.flac")
+	if err := os.WriteFile(name, []byte("ID3\x04"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTags(name); err == nil {
+		t.Error("不是FLAC文件时应返回错误")
+	}
+	// 元数据块不完整
+	if err := WriteFile(name, testTrack(1), mp4.Tags{Title: "标题", Cover: make([]byte, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(name, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTags(name); err == nil {
+		t.Error("文件不完整时应返回错误")
+	}
+}
@@ -0,0 +1,387 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"m4s-converter/mp4"
+	"os"
+)
+
+// 标签类型
+const (
+	tagAudio = 8
+	tagVideo = 9
+)
+
+// 编码类型
+const (
+	codecAVC = 7  // 视频
+	codecAAC = 10 // 音频
+)
+
+// videoTimescale FLV的时间戳单位为毫秒
+const videoTimescale = 1000
+
+// restartThreshold 判断分段时间戳重新开始的阈值，单位为毫秒
+const restartThreshold = 1000
+
+// aacFrameSamples 每个AAC帧的采样数
+const aacFrameSamples = 1024
+
+// File 由一个或多个FLV分段按顺序拼接得到的音视频轨道，样本数据仍在分段文件中
+type File struct {
+	Video *mp4.Track
+	Audio *mp4.Track
+	files []*os.File
+}
+
+// Open 按顺序打开FLV分段，每个分段的时间戳从0开始时接在上一个分段之后
+func Open(names ...string) (*File, error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}
+	var readers []*io.SectionReader
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, file)
+		st, err := file.Stat()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, io.NewSectionReader(file, 0, st.Size()))
+	}
+	f, err := Parse(readers...)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	f.files = files
+	return f, nil
+}
+
+// Parse 按顺序解析FLV分段，分段需在File使用期间保持可读
+func Parse(readers ...*io.SectionReader) (*File, error) {
+	if len(readers) == 0 {
+		return nil, errors.New("没有FLV分段")
+	}
+	f := &File{}
+	src := &segments{}
+	d := &demuxer{}
+	for i, r := range readers {
+		if err := d.segment(r, src.size); err != nil {
+			return nil, fmt.Errorf("第%d个分段: %v", i+1, err)
+		}
+		src.add(r, r.Size())
+	}
+	if err := d.finish(f, src); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close 关闭Open打开的分段文件
+func (f *File) Close() error {
+	var err error
+	for _, file := range f.files {
+		if e := file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	f.files = nil
+	return err
+}
+
+// Tracks 返回存在的轨道，视频在前
+func (f *File) Tracks() []*mp4.Track {
+	var tracks []*mp4.Track
+	for _, t := range []*mp4.Track{f.Video, f.Audio} {
+		if t != nil {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// frame 解析出的一帧，dts为修正后的毫秒时间戳
+type frame struct {
+	dts    int64
+	cts    int32
+	offset int64 // 在拼接后的数据中的位置
+	size   uint32
+	sync   bool
+}
+
+// demuxer 逐个解析分段并修正时间戳
+type demuxer struct {
+	avcC   []byte
+	asc    []byte
+	video  []frame
+	audio  []frame
+	shift  int64 // 当前分段的时间戳偏移
+	end    int64 // 已解析内容的结束时间
+	prev   int64 // 上一个视频帧的时间戳，用于推算帧间隔
+	frameD int64 // 视频帧间隔
+}
+
+// segment 解析一个分段，base为分段在拼接后数据中的起始位置
+func (d *demuxer) segment(r *io.SectionReader, base int64) error {
+	br := bufio.NewReaderSize(io.NewSectionReader(r, 0, 1<<62), 64<<10)
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:3]) != "FLV" {
+		return errors.New("不是FLV文件")
+	}
+	skip := int64(binary.BigEndian.Uint32(header[5:])) - 9 + 4 // 剩余头部和PreviousTagSize0
+	if _, err := br.Discard(int(skip)); err != nil {
+		return err
+	}
+	off := 9 + skip
+	first := true
+	th := make([]byte, 11)
+	for {
+		if _, err := io.ReadFull(br, th); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil // 文件末尾或最后一个标签不完整
+			}
+			return err
+		}
+		typ := th[0] & 0x1F
+		size := int64(th[1])<<16 | int64(th[2])<<8 | int64(th[3])
+		ts := int64(uint32(th[7])<<24 | uint32(th[4])<<16 | uint32(th[5])<<8 | uint32(th[6]))
+		data := off + 11
+		off = data + size + 4
+		if data+size > r.Size() {
+			return nil // 最后一个标签不完整，丢弃
+		}
+
+		var body []byte
+		if typ == tagAudio || typ == tagVideo {
+			n := min(size, 5)
+			if typ == tagAudio {
+				n = min(size, 2)
+			}
+			body = make([]byte, n)
+			if _, err := io.ReadFull(br, body); err != nil {
+				return nil
+			}
+			size -= n
+			data += n
+		}
+		// 分段的时间戳重新开始时，接在已解析内容之后，相差不到1秒时视为连续。
+		// 编码参数的时间戳总是0，以第一个音视频帧判断
+		if first && len(body) >= 2 && body[1] == 1 {
+			first = false
+			if ts+d.shift+restartThreshold < d.end {
+				d.shift = d.end - ts
+			}
+		}
+		ts += d.shift
+
+		switch typ {
+		case tagVideo:
+			if err := d.videoTag(body, ts, base+data, size, br); err != nil {
+				return err
+			}
+		case tagAudio:
+			if err := d.audioTag(body, ts, base+data, size, br); err != nil {
+				return err
+			}
+		}
+		if _, err := br.Discard(int(size) + 4); err != nil {
+			return nil
+		}
+	}
+}
+
+func (d *demuxer) videoTag(h []byte, ts, data, size int64, br *bufio.Reader) error {
+	if len(h) < 5 {
+		return nil
+	}
+	if h[0]&0x0F != codecAVC {
+		return fmt.Errorf("不支持的FLV视频编码: %d", h[0]&0x0F)
+	}
+	switch h[1] {
+	case 0: // AVCDecoderConfigurationRecord
+		if d.avcC == nil {
+			b, err := br.Peek(int(size))
+			if err != nil {
+				return err
+			}
+			d.avcC = append([]byte(nil), b...)
+		}
+	case 1:
+		if size == 0 {
+			return nil
+		}
+		cts := int32(uint32(h[2])<<16|uint32(h[3])<<8|uint32(h[4])) << 8 >> 8
+		if n := len(d.video); n > 0 && ts < d.video[n-1].dts {
+			ts = d.video[n-1].dts
+		}
+		if n := len(d.video); n > 0 && ts > d.prev {
+			d.frameD = ts - d.prev
+		}
+		d.prev = ts
+		d.video = append(d.video, frame{dts: ts, cts: cts, offset: data, size: uint32(size), sync: h[0]>>4 == 1})
+		d.end = max(d.end, ts+d.frameD)
+	}
+	return nil
+}
+
+func (d *demuxer) audioTag(h []byte, ts, data, size int64, br *bufio.Reader) error {
+	if len(h) < 2 {
+		return nil
+	}
+	if h[0]>>4 != codecAAC {
+		return fmt.Errorf("不支持的FLV音频编码: %d", h[0]>>4)
+	}
+	switch h[1] {
+	case 0: // AudioSpecificConfig
+		if d.asc == nil {
+			b, err := br.Peek(int(size))
+			if err != nil {
+				return err
+			}
+			d.asc = append([]byte(nil), b...)
+		}
+	case 1:
+		if size == 0 {
+			return nil
+		}
+		d.audio = append(d.audio, frame{dts: ts, offset: data, size: uint32(size), sync: true})
+		d.end = max(d.end, ts)
+	}
+	return nil
+}
+
+// finish 生成轨道，视频时长按相邻帧的时间戳计算，AAC每帧固定为1024个采样
+func (d *demuxer) finish(f *File, src io.ReaderAt) error {
+	if len(d.video) > 0 {
+		if d.avcC == nil {
+			return errors.New("FLV中找不到H.264的编码参数")
+		}
+		width, height, err := avcSize(d.avcC)
+		if err != nil {
+			return err
+		}
+		t := &mp4.Track{
+			Handler:     mp4.Video,
+			Timescale:   videoTimescale,
+			Language:    "und",
+			Width:       width,
+			Height:      height,
+			Codec:       "avc1",
+			SampleEntry: mp4.AVCSampleEntry(width, height, d.avcC),
+			MediaTime:   -1,
+			Source:      src,
+		}
+		for i, v := range d.video {
+			duration := d.frameD
+			if i+1 < len(d.video) {
+				duration = d.video[i+1].dts - v.dts
+			}
+			t.Samples = append(t.Samples, mp4.Sample{
+				Offset:   v.offset,
+				Size:     v.size,
+				Duration: uint32(duration),
+				CTO:      v.cts,
+				Sync:     v.sync,
+			})
+		}
+		// 第一帧的显示时间不为0时用编辑列表跳过
+		if cts := d.video[0].cts; cts > 0 {
+			t.MediaTime = int64(cts)
+		}
+		f.Video = t
+	}
+	if len(d.audio) > 0 {
+		if d.asc == nil {
+			return errors.New("FLV中找不到AAC的编码参数")
+		}
+		channels, sampleRate, err := ascFormat(d.asc)
+		if err != nil {
+			return err
+		}
+		t := &mp4.Track{
+			Handler:     mp4.Audio,
+			Timescale:   uint32(sampleRate),
+			Language:    "und",
+			Codec:       "mp4a",
+			SampleEntry: mp4.AACSampleEntry(channels, sampleRate, d.asc),
+			MediaTime:   -1,
+			Source:      src,
+		}
+		for _, a := range d.audio {
+			t.Samples = append(t.Samples, mp4.Sample{Offset: a.offset, Size: a.size, Duration: aacFrameSamples, Sync: true})
+		}
+		f.Audio = t
+	}
+	if f.Video == nil && f.Audio == nil {
+		return errors.New("FLV中没有音视频数据")
+	}
+	return nil
+}
+
+// avcSize 从AVCDecoderConfigurationRecord的第一个SPS中读取画面宽高
+func avcSize(avcC []byte) (uint32, uint32, error) {
+	if len(avcC) < 8 || avcC[5]&0x1F == 0 {
+		return 0, 0, errors.New("H.264编码参数中没有SPS")
+	}
+	n := int(binary.BigEndian.Uint16(avcC[6:]))
+	if 8+n > len(avcC) {
+		return 0, 0, errors.New("H.264编码参数不完整")
+	}
+	return parseSPS(avcC[8 : 8+n])
+}
+
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ascFormat 从AudioSpecificConfig中读取声道数和采样率
+func ascFormat(asc []byte) (channels, sampleRate int, err error) {
+	r := &bitReader{b: asc}
+	if r.bits(5) == 31 {
+		r.bits(6)
+	}
+	if i := r.bits(4); i == 15 {
+		sampleRate = int(r.bits(24))
+	} else if int(i) < len(sampleRates) {
+		sampleRate = sampleRates[i]
+	}
+	channels = int(r.bits(4))
+	if r.err != nil || sampleRate == 0 {
+		return 0, 0, errors.New("无效的AAC编码参数")
+	}
+	return channels, sampleRate, nil
+}
+
+// segments 将多个分段文件拼接为一个连续的数据源
+type segments struct {
+	files []io.ReaderAt
+	bases []int64
+	size  int64
+}
+
+func (s *segments) add(r io.ReaderAt, size int64) {
+	s.files = append(s.files, r)
+	s.bases = append(s.bases, s.size)
+	s.size += size
+}
+
+// ReadAt 样本不会跨越分段，按起始位置所在的分段读取
+func (s *segments) ReadAt(p []byte, off int64) (int, error) {
+	for i := len(s.bases) - 1; i >= 0; i-- {
+		if off >= s.bases[i] {
+			return s.files[i].ReadAt(p, off-s.bases[i])
+		}
+	}
+	return 0, io.EOF
+}
@@ -0,0 +1,258 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"m4s-converter/mp4"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bitBytes 将01字符串按位组成字节，不足一个字节时末尾补0
+func bitBytes(bits string) []byte {
+	b := make([]byte, (len(bits)+7)/8)
+	for i, c := range bits {
+		if c == '1' {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return b
+}
+
+// testAVCC 64x48画面的Baseline AVCDecoderConfigurationRecord
+func testAVCC() []byte {
+	// seq_parameter_set_id、log2_max_frame_num_minus4、pic_order_cnt_type、log2_max_pic_order_cnt_lsb_minus4均为0，
+	// max_num_ref_frames为1，宽4个宏块、高3个宏块，帧编码，没有裁剪和VUI
+	sps := append([]byte{0x67, 66, 0, 30}, bitBytes("1"+"1"+"1"+"1"+"010"+"0"+"00100"+"011"+"1"+"1"+"0"+"0"+"1")...)
+	avcC := []byte{1, 66, 0, 30, 0xFF, 0xE1}
+	avcC = binary.BigEndian.AppendUint16(avcC, uint16(len(sps)))
+	return append(append(avcC, sps...), 1, 0, 2, 0x68, 0xCE)
+}
+
+// tag 一个FLV标签
+type tag struct {
+	typ  byte
+	ts   int64
+	body []byte
+}
+
+func avcHeader() tag {
+	return tag{tagVideo, 0, append([]byte{0x17, 0, 0, 0, 0}, testAVCC()...)}
+}
+
+// videoFrame 时间戳为ts、显示时间偏移为cts的视频帧，帧数据为4个n
+func videoFrame(ts int64, cts int32, n byte) tag {
+	c := uint32(cts)
+	return tag{tagVideo, ts, []byte{0x17, 1, byte(c >> 16), byte(c >> 8), byte(c), n, n, n, n}}
+}
+
+func aacHeader() tag {
+	return tag{tagAudio, 0, []byte{0xAF, 0, 0x12, 0x10}} // AAC LC，44100Hz，双声道
+}
+
+func audioFrame(ts int64, n byte) tag {
+	return tag{tagAudio, ts, []byte{0xAF, 1, n, n, n}}
+}
+
+// segment 生成包含指定标签的FLV分段
+func segment(tags ...tag) []byte {
+	b := []byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0}
+	for _, t := range tags {
+		size := len(t.body)
+		b = append(b, t.typ, byte(size>>16), byte(size>>8), byte(size),
+			byte(t.ts>>16), byte(t.ts>>8), byte(t.ts), byte(t.ts>>24), 0, 0, 0)
+		b = append(b, t.body...)
+		b = binary.BigEndian.AppendUint32(b, uint32(11+size))
+	}
+	return b
+}
+
+// headers 分段开头的编码参数
+func headers(tags ...tag) []tag {
+	return append([]tag{avcHeader(), aacHeader()}, tags...)
+}
+
+func TestDemuxer(t *testing.T) {
+	tests := []struct {
+		name      string
+		segments  [][]tag
+		truncate  int     // 去掉最后一个分段末尾的字节数
+		video     []int64 // 修正后的视频帧时间戳
+		durations []uint32
+		audio     []int64
+		mediaTime int64
+	}{
+		{
+			name:      "单个分段",
+			segments:  [][]tag{headers(videoFrame(0, 0, 0), audioFrame(0, 0), videoFrame(40, 0, 1), audioFrame(23, 1), videoFrame(80, 0, 2))},
+			video:     []int64{0, 40, 80},
+			durations: []uint32{40, 40, 40},
+			audio:     []int64{0, 23},
+			mediaTime: -1,
+		},
+		{
+			name: "时间戳重新开始",
+			segments: [][]tag{
+				headers(videoFrame(0, 0, 0), audioFrame(0, 0), videoFrame(1000, 0, 1), videoFrame(2000, 0, 2)),
+				headers(videoFrame(0, 0, 3), audioFrame(10, 1), videoFrame(1000, 0, 4)),
+			},
+			video:     []int64{0, 1000, 2000, 3000, 4000},
+			durations: []uint32{1000, 1000, 1000, 1000, 1000},
+			audio:     []int64{0, 3010},
+			mediaTime: -1,
+		},
+		{
+			name: "时间戳连续",
+			segments: [][]tag{
+				headers(videoFrame(0, 0, 0), videoFrame(40, 0, 1), videoFrame(80, 0, 2)),
+				headers(videoFrame(120, 0, 3), videoFrame(160, 0, 4)),
+			},
+			video:     []int64{0, 40, 80, 120, 160},
+			durations: []uint32{40, 40, 40, 40, 40},
+			mediaTime: -1,
+		},
+		{
+			// 已解析内容结束于3000，第二个分段从2500开始，相差不到1秒视为连续
+			name: "相差不到阈值",
+			segments: [][]tag{
+				headers(videoFrame(0, 0, 0), videoFrame(1000, 0, 1), videoFrame(2000, 0, 2)),
+				headers(videoFrame(2500, 0, 3)),
+			},
+			video:     []int64{0, 1000, 2000, 2500},
+			durations: []uint32{1000, 1000, 500, 500},
+			mediaTime: -1,
+		},
+		{
+			// 第二个分段从1500开始，比结束时间早1秒以上，视为重新开始并接在3000之后
+			name: "超过阈值",
+			segments: [][]tag{
+				headers(videoFrame(0, 0, 0), videoFrame(1000, 0, 1), videoFrame(2000, 0, 2)),
+				headers(videoFrame(1500, 0, 3), videoFrame(2500, 0, 4)),
+			},
+			video:     []int64{0, 1000, 2000, 3000, 4000},
+			durations: []uint32{1000, 1000, 1000, 1000, 1000},
+			mediaTime: -1,
+		},
+		{
+			name:      "时间戳倒退",
+			segments:  [][]tag{headers(videoFrame(0, 0, 0), videoFrame(80, 0, 1), videoFrame(40, 0, 2), videoFrame(120, 0, 3))},
+			video:     []int64{0, 80, 80, 120},
+			durations: []uint32{80, 0, 40, 40},
+			mediaTime: -1,
+		},
+		{
+			name:      "第一帧的显示时间",
+			segments:  [][]tag{headers(videoFrame(0, 80, 0), videoFrame(40, 120, 1), videoFrame(80, 0, 2))},
+			video:     []int64{0, 40, 80},
+			durations: []uint32{40, 40, 40},
+			mediaTime: 80,
+		},
+		{
+			name: "最后一个标签不完整",
+			segments: [][]tag{
+				headers(videoFrame(0, 0, 0), audioFrame(0, 0), videoFrame(1000, 0, 1)),
+				headers(videoFrame(0, 0, 2), audioFrame(23, 1), videoFrame(1000, 0, 3)),
+			},
+			truncate:  6, // 去掉PreviousTagSize和2字节帧数据
+			video:     []int64{0, 1000, 2000},
+			durations: []uint32{1000, 1000, 1000},
+			audio:     []int64{0, 2023},
+			mediaTime: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readers []*io.SectionReader
+			for i, tags := range tt.segments {
+				b := segment(tags...)
+				if i == len(tt.segments)-1 {
+					b = b[:len(b)-tt.truncate]
+				}
+				readers = append(readers, io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))))
+			}
+			d := &demuxer{}
+			src := &segments{}
+			for _, r := range readers {
+				if err := d.segment(r, src.size); err != nil {
+					t.Fatal(err)
+				}
+				src.add(r, r.Size())
+			}
+			if got := dts(d.video); !reflect.DeepEqual(got, tt.video) {
+				t.Fatalf("视频时间戳为 %v, 期望 %v", got, tt.video)
+			}
+			if got := dts(d.audio); !reflect.DeepEqual(got, tt.audio) {
+				t.Fatalf("音频时间戳为 %v, 期望 %v", got, tt.audio)
+			}
+
+			f := &File{}
+			if err := d.finish(f, src); err != nil {
+				t.Fatal(err)
+			}
+			if f.Video.Width != 64 || f.Video.Height != 48 {
+				t.Fatalf("画面为 %dx%d", f.Video.Width, f.Video.Height)
+			}
+			if f.Video.MediaTime != tt.mediaTime {
+				t.Fatalf("编辑列表起点为 %d, 期望 %d", f.Video.MediaTime, tt.mediaTime)
+			}
+			var durations []uint32
+			for _, s := range f.Video.Samples {
+				durations = append(durations, s.Duration)
+			}
+			if !reflect.DeepEqual(durations, tt.durations) {
+				t.Fatalf("视频帧时长为 %v, 期望 %v", durations, tt.durations)
+			}
+			checkSamples(t, f.Video)
+			if f.Audio != nil {
+				if f.Audio.Timescale != 44100 {
+					t.Fatalf("音频采样率为 %d", f.Audio.Timescale)
+				}
+				checkSamples(t, f.Audio)
+			}
+		})
+	}
+}
+
+// dts 帧的时间戳
+func dts(frames []frame) []int64 {
+	var ts []int64
+	for _, f := range frames {
+		ts = append(ts, f.dts)
+	}
+	return ts
+}
+
+// checkSamples 按拼接后的位置读取样本，第i个样本的数据应全部为i
+func checkSamples(t *testing.T, track *mp4.Track) {
+	t.Helper()
+	for i, s := range track.Samples {
+		b := make([]byte, s.Size)
+		if _, err := track.Source.ReadAt(b, s.Offset); err != nil {
+			t.Fatalf("%s 第%d个样本: %v", track.Handler, i, err)
+		}
+		if !bytes.Equal(b, bytes.Repeat([]byte{byte(i)}, len(b))) {
+			t.Fatalf("%s 第%d个样本为 %v", track.Handler, i, b)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"不是FLV", []byte("ftypisom"), "不是FLV文件"},
+		{"没有音视频", segment(avcHeader(), aacHeader()), "没有音视频数据"},
+		{"缺少编码参数", segment(videoFrame(0, 0, 0)), "H.264的编码参数"},
+		{"不支持的编码", segment(tag{tagVideo, 0, []byte{0x12, 1, 0, 0, 0, 1}}), "不支持的FLV视频编码"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(io.NewSectionReader(bytes.NewReader(tt.data), 0, int64(len(tt.data))))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: 错误为 %v, 期望包含 %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -5,28 +5,26 @@ go 1.23
 require (
 	github.com/Masterminds/semver v1.5.0
 	github.com/bingoohuang/golog v0.0.0-20240909041443-283abc3a5ce0
-	github.com/bitly/go-simplejson v0.5.1
 	github.com/fatih/color v1.18.0
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/google/go-github/v65 v65.0.0
 	github.com/integrii/flaggy v1.5.2
+	github.com/mattn/go-isatty v0.0.20
 	github.com/mzky/converter v0.0.0-20240218092920-bfbd07560669
 	github.com/mzky/utils v1.6.2
 	github.com/ncruces/zenity v0.10.14
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.3
-	github.com/tidwall/gjson v1.18.0
 )
 
 require (
 	github.com/akavel/rsrc v0.10.2 // indirect
 	github.com/dchest/jsmin v0.0.0-20220218165748-59f39799265f // indirect
-	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/josephspurrier/goversioninfo v1.4.1 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mzky/zip v0.0.0-20240709011722-16a3ac64cd1d // indirect
 	github.com/pelletier/go-toml/v2 v2.2.2 // indirect
@@ -40,8 +38,6 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.19.0 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
-	github.com/tidwall/match v1.1.1 // indirect
-	github.com/tidwall/pretty v1.2.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/crypto v0.29.0 // indirect
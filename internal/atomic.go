@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// partialFiles 正在写入的临时文件，强制退出时删除
+var partialFiles sync.Map
+
+// Writing 记录正在写入的文件，返回写入结束时调用的函数
+func Writing(name string) func() {
+	partialFiles.Store(name, struct{}{})
+	return func() { partialFiles.Delete(name) }
+}
+
+// RemovePartialFiles 删除所有正在写入的文件
+func RemovePartialFiles() {
+	partialFiles.Range(func(k, _ any) bool {
+		if err := os.Remove(k.(string)); err == nil {
+			logrus.Warn("已删除未写完的文件: ", k)
+		}
+		return true
+	})
+}
+
+// TempName 与name同目录的临时文件名，以.开头且保留扩展名，外部程序可按扩展名识别输出格式
+func TempName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(filepath.Base(name), ext)
+	return filepath.Join(filepath.Dir(name), fmt.Sprintf(".%s.%d.tmp%s", base, os.Getpid(), ext))
+}
+
+// tempRe TempName 生成的文件名
+var tempRe = regexp.MustCompile(`^\..+\.\d+\.tmp(\.[^.]+)?$`)
+
+// IsTemp 是否为 TempName 生成的临时文件
+func IsTemp(name string) bool {
+	return tempRe.MatchString(filepath.Base(name))
+}
+
+// WriteFile 先由write写入同目录的临时文件并同步到磁盘，成功后重命名为name。
+// 失败时删除临时文件，name不会出现写了一半的内容。name本身是临时文件时直接写入
+func WriteFile(name string, write func(f *os.File) error) error {
+	tmp := name
+	if !IsTemp(name) {
+		tmp = TempName(name)
+	}
+	defer Writing(tmp)()
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil && tmp != name {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
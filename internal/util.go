@@ -1,25 +1,24 @@
 package internal
 
 import (
-	"bytes"
-	"github.com/sirupsen/logrus"
-	"os"
 	"os/exec"
-	"strings"
 )
 
-// getCliPath 获取命令绝对路径
-func getCliPath(name string) string {
-	execCmd := exec.Command("which", name)
+// GetMP4Box 在PATH中查找MP4Box，找不到时返回空
+func GetMP4Box() string {
+	return getCliPath("MP4Box")
+}
 
-	var stdout bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stdout
+// GetFFmpeg 在PATH中查找ffmpeg，找不到时返回空
+func GetFFmpeg() string {
+	return getCliPath("ffmpeg")
+}
 
-	if err := execCmd.Run(); err != nil {
-		logrus.Errorf("找不到MP4Box命令,安装GPAC后重试: %v, %v", err, stdout.String())
-		os.Exit(1)
+// getCliPath 获取命令绝对路径，找不到时返回空
+func getCliPath(name string) string {
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return ""
 	}
-
-	return strings.TrimSpace(stdout.String())
+	return p
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"m4s-converter/common"
+	"m4s-converter/pipeline"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,19 +13,28 @@ import (
 
 func main() {
 	var c common.Config
+	defer c.PanicHandler()
 	c.InitLog()
 	c.InitConfig()
 
-	// 捕获 SIGINT 信号（Ctrl+C）
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT)
+	// 捕获 SIGINT 信号（Ctrl+C），停止正在进行的合成并删除未完成的文件后退出
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// 在 goroutine 中等待信号
+	// 在 goroutine 中等待信号，再次收到信号时强制退出
 	go func() {
 		<-sigChan
-		logrus.Info("收到退出信号，正在处理当前任务...")
-		c.SetExitFlag(true)
+		logrus.Info("收到退出信号，正在停止当前任务，再次按Ctrl+C强制退出...")
+		cancel()
+		<-sigChan
+		logrus.Warn("强制退出")
+		pipeline.RemovePartialFiles()
+		os.Exit(common.ExitCanceled)
 	}()
 
-	c.Synthesis()
+	if c.Serve {
+		c.StartServer(ctx)
+	}
+	c.Synthesis(ctx)
 }
@@ -0,0 +1,181 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Matroska 元素ID
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+	idVoid               = 0xEC
+
+	idSegment           = 0x18538067
+	idSeekHead          = 0x114D9B74
+	idSeek              = 0x4DBB
+	idSeekID            = 0x53AB
+	idSeekPosition      = 0x53AC
+	idInfo              = 0x1549A966
+	idTimestampScale    = 0x2AD7B1
+	idDuration          = 0x4489
+	idMuxingApp         = 0x4D80
+	idWritingApp        = 0x5741
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idFlagDefault       = 0x88
+	idFlagLacing        = 0x9C
+	idLanguage          = 0x22B59C
+	idName              = 0x536E
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+	idTags              = 0x1254C367
+	idTag               = 0x7373
+	idTargets           = 0x63C0
+	idSimpleTag         = 0x67C8
+	idTagName           = 0x45A3
+	idTagString         = 0x4487
+	idCluster           = 0x1F43B675
+	idTimestamp         = 0xE7
+	idSimpleBlock       = 0xA3
+	idBlockGroup        = 0xA0
+	idBlock             = 0xA1
+	idBlockDuration     = 0x9B
+	idCues              = 0x1C53BB6B
+	idCuePoint          = 0xBB
+	idCueTime           = 0xB3
+	idCueTrackPositions = 0xB7
+	idCueTrack          = 0xF7
+	idCueClusterPos     = 0xF1
+)
+
+// 轨道类型
+const (
+	TrackVideo    = 1
+	TrackAudio    = 2
+	TrackSubtitle = 0x11
+)
+
+// unknownSize 8字节长度的占位值，写入完成后回填实际长度
+var unknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+func encodeID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return binary.BigEndian.AppendUint32(nil, id)
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// encodeSize 以最短的变长整数编码长度
+func encodeSize(n uint64) []byte {
+	l := 1
+	for l < 8 && n >= 1<<(7*l)-1 {
+		l++
+	}
+	return encodeSizeLen(n, l)
+}
+
+// encodeSizeLen 以固定字节数编码长度
+func encodeSizeLen(n uint64, l int) []byte {
+	b := make([]byte, l)
+	for i := l - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	b[0] |= 0x80 >> (l - 1)
+	return b
+}
+
+func elem(id uint32, payload ...[]byte) []byte {
+	n := 0
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := append(encodeID(id), encodeSize(uint64(n))...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func uintElem(id uint32, v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return elem(id, b)
+}
+
+func floatElem(id uint32, v float64) []byte {
+	return elem(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func strElem(id uint32, s string) []byte {
+	return elem(id, []byte(s))
+}
+
+// void 生成总长度为n的Void元素，n至少为2
+func void(n int) []byte {
+	l := 1
+	for l < 8 && n-1-l >= 1<<(7*l)-1 {
+		l++
+	}
+	return append(append([]byte{idVoid}, encodeSizeLen(uint64(n-1-l), l)...), make([]byte, n-1-l)...)
+}
+
+var errInvalid = errors.New("无效的Matroska文件")
+
+// readVint 读取变长整数，mask为true时去掉长度标记位（用于长度），返回值和占用字节数
+func readVint(r io.ReaderAt, off int64, mask bool) (uint64, int, error) {
+	var b [8]byte
+	if _, err := r.ReadAt(b[:1], off); err != nil {
+		return 0, 0, err
+	}
+	l := 1
+	for l <= 8 && b[0]&(0x80>>(l-1)) == 0 {
+		l++
+	}
+	if l > 8 {
+		return 0, 0, errInvalid
+	}
+	if l > 1 {
+		if _, err := r.ReadAt(b[1:l], off+1); err != nil {
+			return 0, 0, err
+		}
+	}
+	v := uint64(b[0])
+	if mask {
+		v &= uint64(0xFF >> l)
+	}
+	allOnes := v == uint64(0xFF>>l)
+	for i := 1; i < l; i++ {
+		v = v<<8 | uint64(b[i])
+		allOnes = allOnes && b[i] == 0xFF
+	}
+	if mask && allOnes {
+		return math.MaxUint64, l, nil // 未知长度
+	}
+	return v, l, nil
+}
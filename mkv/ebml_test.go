@@ -0,0 +1,40 @@
+package mkv
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestVint(t *testing.T) {
+	// 全1的值表示未知长度，编码时使用更长的字节数
+	for _, n := range []uint64{0, 1, 126, 127, 128, 16382, 16383, 1 << 20, 1<<56 - 2} {
+		b := encodeSize(n)
+		v, l, err := readVint(bytes.NewReader(b), 0, true)
+		if err != nil || v != n || l != len(b) {
+			t.Errorf("%d 编码为 %x, 读取为 %d, %d字节: %v", n, b, v, l, err)
+		}
+	}
+	if v, _, _ := readVint(bytes.NewReader(unknownSize), 0, true); v != math.MaxUint64 {
+		t.Errorf("未知长度读取为 %d", v)
+	}
+	for _, id := range []uint32{idVoid, idTrackEntry, idCodecPrivate, idSegment} {
+		v, _, err := readVint(bytes.NewReader(encodeID(id)), 0, false)
+		if err != nil || uint32(v) != id {
+			t.Errorf("ID %x 读取为 %x: %v", id, v, err)
+		}
+	}
+	if _, _, err := readVint(bytes.NewReader([]byte{0}), 0, true); err != errInvalid {
+		t.Errorf("第一个字节为0时应返回错误: %v", err)
+	}
+}
+
+func TestVoid(t *testing.T) {
+	for _, n := range []int{2, 10, 128, 129, 130, 1000} {
+		b := void(n)
+		e, err := readElement(bytes.NewReader(b), 0)
+		if len(b) != n || err != nil || e.id != idVoid || e.data+e.size != int64(n) {
+			t.Errorf("void(%d) 长度为 %d, 元素为 %+v: %v", n, len(b), e, err)
+		}
+	}
+}
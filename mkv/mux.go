@@ -0,0 +1,478 @@
+package mkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"m4s-converter/mp4"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// clusterDuration 每个Cluster的最短时长，单位为毫秒，新的Cluster从视频关键帧开始
+const clusterDuration = 5000
+
+// seekHeadSize 为SeekHead预留的长度
+const seekHeadSize = 128
+
+const appName = "m4s-converter"
+
+// Subtitle 文本字幕轨道
+type Subtitle struct {
+	CodecID  string // 如 S_TEXT/ASS
+	Private  []byte // 编码参数，ASS为[Events]之前的全部内容
+	Name     string
+	Language string // ISO-639-2 语言代码
+	Default  bool
+	Events   []Event
+}
+
+// Event 字幕中的一条事件
+type Event struct {
+	Start time.Duration
+	End   time.Duration
+	Data  []byte
+}
+
+// block 一个SimpleBlock或BlockGroup
+type block struct {
+	track    int   // 轨道编号，从1开始
+	dts      int64 // 解码时间，单位为毫秒，用于交错排序
+	pts      int64 // 显示时间，单位为毫秒
+	key      bool
+	video    bool
+	duration int64 // 字幕的持续时间，大于0时写为BlockGroup
+	source   *mp4.Track
+	sample   mp4.Sample
+	data     []byte
+}
+
+func (b *block) dataSize() int64 {
+	if b.source != nil {
+		return int64(b.sample.Size)
+	}
+	return int64(len(b.data))
+}
+
+// blockSize Block内容长度：轨道编号、相对时间、标志和数据
+func (b *block) blockSize() int64 {
+	return int64(len(encodeSize(uint64(b.track)))) + 3 + b.dataSize()
+}
+
+// elemSize 包含元素头部的长度
+func (b *block) elemSize() int64 {
+	n := b.blockSize()
+	n += 1 + int64(len(encodeSize(uint64(n))))
+	if b.duration > 0 {
+		n += int64(len(uintElem(idBlockDuration, uint64(b.duration))))
+		n += 1 + int64(len(encodeSize(uint64(n))))
+	}
+	return n
+}
+
+// cluster 一组时间相近的块
+type cluster struct {
+	time   int64
+	start  int64 // 第一个块的解码时间
+	blocks []*block
+	size   int64 // 内容长度
+	pos    int64 // 相对于Segment内容的位置
+}
+
+// WriteFile 将音视频轨道和字幕合成为Matroska文件
+func WriteFile(name string, tracks []*mp4.Track, subs []*Subtitle, tags mp4.Tags) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = Write(f, tracks, subs, tags); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write 将轨道写入w。各部分长度在写入前全部计算好，不需要回写
+func Write(w io.Writer, tracks []*mp4.Track, subs []*Subtitle, tags mp4.Tags) error {
+	if len(tracks) == 0 {
+		return errors.New("没有可写入的轨道")
+	}
+	var entries [][]byte
+	for i, t := range tracks {
+		e, err := trackEntry(i+1, t)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	for i, s := range subs {
+		entries = append(entries, subtitleEntry(len(tracks)+i+1, s))
+	}
+
+	var duration time.Duration
+	for _, t := range tracks {
+		duration = max(duration, t.Time())
+	}
+	info := elem(idInfo,
+		uintElem(idTimestampScale, uint64(time.Millisecond)),
+		floatElem(idDuration, float64(duration)/float64(time.Millisecond)),
+		strElem(idMuxingApp, appName),
+		strElem(idWritingApp, appName))
+	tracksElem := elem(idTracks, entries...)
+	tagsElem := buildTags(tags)
+
+	clusters := buildClusters(collectBlocks(tracks, subs))
+	pos := int64(seekHeadSize)
+	infoPos := pos
+	pos += int64(len(info))
+	tracksPos := pos
+	pos += int64(len(tracksElem))
+	tagsPos := pos
+	pos += int64(len(tagsElem))
+	for _, c := range clusters {
+		c.pos = pos
+		pos += clusterHeaderSize(c) + c.size
+	}
+	cues := buildCues(clusters)
+	cuesPos := pos
+	pos += int64(len(cues))
+
+	seeks := [][]byte{seek(idInfo, infoPos), seek(idTracks, tracksPos)}
+	if tagsElem != nil {
+		seeks = append(seeks, seek(idTags, tagsPos))
+	}
+	if cues != nil {
+		seeks = append(seeks, seek(idCues, cuesPos))
+	}
+	seekHead := elem(idSeekHead, seeks...)
+	seekHead = append(seekHead, void(seekHeadSize-len(seekHead))...)
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	segment := append(encodeID(idSegment), encodeSizeLen(uint64(pos), 8)...)
+	for _, b := range [][]byte{ebmlHeader(), segment, seekHead, info, tracksElem, tagsElem} {
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, c := range clusters {
+		if err := writeCluster(bw, c); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(cues); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func ebmlHeader() []byte {
+	return elem(idEBML,
+		uintElem(idEBMLVersion, 1),
+		uintElem(idEBMLReadVersion, 1),
+		uintElem(idEBMLMaxIDLength, 4),
+		uintElem(idEBMLMaxSizeLength, 8),
+		strElem(idDocType, "matroska"),
+		uintElem(idDocTypeVersion, 4),
+		uintElem(idDocTypeReadVersion, 2))
+}
+
+func seek(id uint32, pos int64) []byte {
+	return elem(idSeek, elem(idSeekID, encodeID(id)), uintElem(idSeekPosition, uint64(pos)))
+}
+
+// codec 返回轨道对应的Matroska CodecID和CodecPrivate
+func codec(t *mp4.Track) (string, []byte, error) {
+	var id string
+	var private []byte
+	switch t.Codec {
+	case "avc1", "avc3":
+		id, private = "V_MPEG4/ISO/AVC", t.CodecBox("avcC")
+	case "hev1", "hvc1":
+		id, private = "V_MPEGH/ISO/HEVC", t.CodecBox("hvcC")
+	case "av01":
+		id, private = "V_AV1", t.CodecBox("av1C")
+	case "mp4a":
+		id, private = "A_AAC", t.DecoderConfig()
+	case "fLaC":
+		// dfLa为full box，去掉版本和标志后就是FLAC的元数据块
+		if dfLa := t.CodecBox("dfLa"); len(dfLa) > 4 {
+			id, private = "A_FLAC", append([]byte("fLaC"), dfLa[4:]...)
+		}
+	case "ec-3":
+		return "A_EAC3", nil, nil
+	case "ac-3":
+		return "A_AC3", nil, nil
+	default:
+		return "", nil, fmt.Errorf("Matroska不支持的编码: %s", t.Codec)
+	}
+	if private == nil {
+		return "", nil, fmt.Errorf("找不到%s的编码参数", t.Codec)
+	}
+	return id, private, nil
+}
+
+func trackEntry(number int, t *mp4.Track) ([]byte, error) {
+	id, private, err := codec(t)
+	if err != nil {
+		return nil, err
+	}
+	var typ uint64
+	var format []byte
+	switch t.Handler {
+	case mp4.Video:
+		typ = TrackVideo
+		format = elem(idVideo,
+			uintElem(idPixelWidth, uint64(t.Width)),
+			uintElem(idPixelHeight, uint64(t.Height)))
+	case mp4.Audio:
+		typ = TrackAudio
+		channels, rate := t.AudioFormat()
+		format = elem(idAudio,
+			floatElem(idSamplingFrequency, float64(rate)),
+			uintElem(idChannels, uint64(channels)))
+	default:
+		return nil, fmt.Errorf("Matroska不支持的轨道类型: %s", t.Handler)
+	}
+	language := t.Language
+	if language == "" {
+		language = "und"
+	}
+	payload := [][]byte{
+		uintElem(idTrackNumber, uint64(number)),
+		uintElem(idTrackUID, uint64(number)),
+		uintElem(idTrackType, typ),
+		uintElem(idFlagLacing, 0),
+		strElem(idLanguage, language),
+		strElem(idCodecID, id),
+	}
+	if private != nil {
+		payload = append(payload, elem(idCodecPrivate, private))
+	}
+	if t.Disabled {
+		payload = append(payload, uintElem(idFlagDefault, 0))
+	}
+	return elem(idTrackEntry, append(payload, format)...), nil
+}
+
+func subtitleEntry(number int, s *Subtitle) []byte {
+	language := s.Language
+	if language == "" {
+		language = "und"
+	}
+	var flagDefault uint64
+	if s.Default {
+		flagDefault = 1
+	}
+	payload := [][]byte{
+		uintElem(idTrackNumber, uint64(number)),
+		uintElem(idTrackUID, uint64(number)),
+		uintElem(idTrackType, TrackSubtitle),
+		uintElem(idFlagDefault, flagDefault),
+		uintElem(idFlagLacing, 0),
+		strElem(idLanguage, language),
+		strElem(idCodecID, s.CodecID),
+	}
+	if s.Private != nil {
+		payload = append(payload, elem(idCodecPrivate, s.Private))
+	}
+	if s.Name != "" {
+		payload = append(payload, strElem(idName, s.Name))
+	}
+	return elem(idTrackEntry, payload...)
+}
+
+// buildTags 生成全局元数据，键名与MP4的元数据对应
+func buildTags(tags mp4.Tags) []byte {
+	var simple [][]byte
+	add := func(name, value string) {
+		if value != "" {
+			simple = append(simple, elem(idSimpleTag, strElem(idTagName, name), strElem(idTagString, value)))
+		}
+	}
+	for _, v := range tagNames {
+		add(v.name, *v.field(&tags))
+	}
+	keys := make([]string, 0, len(tags.Custom))
+	for k := range tags.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, tags.Custom[k])
+	}
+	if simple == nil {
+		return nil
+	}
+	return elem(idTags, elem(idTag, append([][]byte{elem(idTargets)}, simple...)...))
+}
+
+// tagNames Matroska标签名与mp4.Tags字段的对应关系
+var tagNames = []struct {
+	name  string
+	field func(*mp4.Tags) *string
+}{
+	{"TITLE", func(t *mp4.Tags) *string { return &t.Title }},
+	{"ARTIST", func(t *mp4.Tags) *string { return &t.Artist }},
+	{"ALBUM", func(t *mp4.Tags) *string { return &t.Album }},
+	{"COMMENT", func(t *mp4.Tags) *string { return &t.Comment }},
+	{"DATE_RELEASED", func(t *mp4.Tags) *string { return &t.Date }},
+	{"COPYRIGHT", func(t *mp4.Tags) *string { return &t.Copyright }},
+}
+
+// msec 将轨道时间刻度转换为毫秒
+func msec(v int64, timescale uint32) int64 {
+	return int64(math.Round(float64(v) * 1000 / float64(timescale)))
+}
+
+// collectBlocks 按解码时间交错排列所有轨道的块
+func collectBlocks(tracks []*mp4.Track, subs []*Subtitle) []*block {
+	var blocks []*block
+	for i, t := range tracks {
+		start := max(t.MediaTime, 0)
+		var dts int64
+		for _, s := range t.Samples {
+			blocks = append(blocks, &block{
+				track:  i + 1,
+				dts:    msec(dts, t.Timescale),
+				pts:    max(msec(dts+int64(s.CTO)-start, t.Timescale), 0),
+				key:    s.Sync,
+				video:  t.Handler == mp4.Video,
+				source: t,
+				sample: s,
+			})
+			dts += int64(s.Duration)
+		}
+	}
+	for i, s := range subs {
+		for _, e := range s.Events {
+			start := e.Start.Milliseconds()
+			blocks = append(blocks, &block{
+				track:    len(tracks) + i + 1,
+				dts:      start,
+				pts:      start,
+				key:      true,
+				duration: max(e.End.Milliseconds()-start, 1),
+				data:     e.Data,
+			})
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].dts < blocks[j].dts
+	})
+	return blocks
+}
+
+// buildClusters 将块分组，有视频时新的Cluster从关键帧开始，块的相对时间超出int16时强制分组
+func buildClusters(blocks []*block) []*cluster {
+	hasVideo := false
+	for _, b := range blocks {
+		hasVideo = hasVideo || b.video
+	}
+	var clusters []*cluster
+	var c *cluster
+	for _, b := range blocks {
+		split := c == nil
+		if !split && b.dts-c.start >= clusterDuration {
+			split = !hasVideo || (b.video && b.key)
+		}
+		if !split {
+			rel := b.pts - c.time
+			split = rel < math.MinInt16 || rel > math.MaxInt16
+		}
+		if split {
+			c = &cluster{time: b.pts, start: b.dts}
+			clusters = append(clusters, c)
+		}
+		c.blocks = append(c.blocks, b)
+		c.size += b.elemSize()
+	}
+	for _, c := range clusters {
+		c.size += int64(len(uintElem(idTimestamp, uint64(c.time))))
+	}
+	return clusters
+}
+
+func clusterHeaderSize(c *cluster) int64 {
+	return int64(len(encodeID(idCluster)) + len(encodeSize(uint64(c.size))))
+}
+
+// buildCues 为以关键帧开始的Cluster建立索引
+func buildCues(clusters []*cluster) []byte {
+	var points [][]byte
+	for _, c := range clusters {
+		b := c.blocks[0]
+		if !b.key || b.duration > 0 {
+			continue
+		}
+		points = append(points, elem(idCuePoint,
+			uintElem(idCueTime, uint64(b.pts)),
+			elem(idCueTrackPositions,
+				uintElem(idCueTrack, uint64(b.track)),
+				uintElem(idCueClusterPos, uint64(c.pos)))))
+	}
+	if points == nil {
+		return nil
+	}
+	return elem(idCues, points...)
+}
+
+func writeCluster(w io.Writer, c *cluster) error {
+	header := append(encodeID(idCluster), encodeSize(uint64(c.size))...)
+	header = append(header, uintElem(idTimestamp, uint64(c.time))...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, b := range c.blocks {
+		if err := writeBlock(w, c, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBlock(w io.Writer, c *cluster, b *block) error {
+	n := uint64(b.blockSize())
+	var header []byte
+	if b.duration > 0 {
+		duration := uintElem(idBlockDuration, uint64(b.duration))
+		inner := uint64(1+len(encodeSize(n))) + n + uint64(len(duration))
+		header = append([]byte{idBlockGroup}, encodeSize(inner)...)
+		header = append(header, idBlock)
+		header = append(header, encodeSize(n)...)
+		header = append(header, blockHeader(c, b)...)
+		header = append(header, b.data...)
+		header = append(header, duration...)
+		_, err := w.Write(header)
+		return err
+	}
+	header = append([]byte{idSimpleBlock}, encodeSize(n)...)
+	header = append(header, blockHeader(c, b)...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if b.source == nil {
+		_, err := w.Write(b.data)
+		return err
+	}
+	_, err := io.Copy(w, io.NewSectionReader(b.source.Source, b.sample.Offset, int64(b.sample.Size)))
+	return err
+}
+
+// blockHeader 轨道编号、相对于Cluster的时间和标志
+func blockHeader(c *cluster, b *block) []byte {
+	h := encodeSize(uint64(b.track))
+	h = binary.BigEndian.AppendUint16(h, uint16(int16(b.pts-c.time)))
+	var flags byte
+	if b.key && b.duration == 0 {
+		flags = 0x80
+	}
+	return append(h, flags)
+}
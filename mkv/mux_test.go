@@ -0,0 +1,129 @@
+package mkv
+
+import (
+	"bytes"
+	"m4s-converter/conver"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testASS 带内嵌字体的弹幕字幕
+const testASS = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, Bold
+Style: R2L,黑体,26,&H00FFFFFF,0
+
+[Fonts]
+fontname: danmaku_0.ttf
+!!!!!!!!!!!!!!!!!!!!
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:00.50,0:00:01.50,R2L,,0,0,0,,第一条弹幕
+Dialogue: 0,0:00:01.00,0:00:02.00,R2L,,0,0,0,,第二条弹幕
+`
+
+// testTrack 时长为n秒的轨道，每秒一个样本
+func testTrack(handler string, n int) *mp4.Track {
+	t := &mp4.Track{Handler: handler, Timescale: 1000, Language: "und", MediaTime: -1,
+		Source: bytes.NewReader(bytes.Repeat([]byte{0xAB}, n*16))}
+	if handler == mp4.Video {
+		avcC := []byte{1, 66, 0, 30, 0xFF, 0xE1, 0, 4, 0x67, 66, 0, 30, 1, 0, 2, 0x68, 0xCE}
+		t.Codec, t.Width, t.Height, t.SampleEntry = "avc1", 64, 48, mp4.AVCSampleEntry(64, 48, avcC)
+	} else {
+		t.Codec, t.SampleEntry = "mp4a", mp4.AACSampleEntry(2, 44100, []byte{0x12, 0x10})
+	}
+	for i := 0; i < n; i++ {
+		t.Samples = append(t.Samples, mp4.Sample{Offset: int64(i * 16), Size: 16, Duration: 1000, Sync: true})
+	}
+	return t
+}
+
+func TestWriteProbe(t *testing.T) {
+	dir := t.TempDir()
+	assFile := filepath.Join(dir, "a.ass")
+	if err := os.WriteFile(assFile, []byte(testASS), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ass, err := conver.ParseAss(assFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &Subtitle{CodecID: "S_TEXT/ASS", Private: []byte(ass.Header), Name: "Danmaku", Language: "chi"}
+	for _, e := range ass.Events {
+		sub.Events = append(sub.Events, Event{Start: e.Start, End: e.End, Data: []byte(e.MatroskaData())})
+	}
+
+	name := filepath.Join(dir, "a.mkv")
+	tags := mp4.Tags{Title: "标题", Artist: "UP主"}
+	if err = WriteFile(name, []*mp4.Track{testTrack(mp4.Video, 3), testTrack(mp4.Audio, 3)}, []*Subtitle{sub}, tags); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Probe(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 3*time.Second {
+		t.Fatalf("时长为 %v", info.Duration)
+	}
+	if info.Tags.Title != tags.Title || info.Tags.Artist != tags.Artist {
+		t.Fatalf("元数据为 %+v", info.Tags)
+	}
+	if len(info.Tracks) != 3 {
+		t.Fatalf("轨道数为 %d", len(info.Tracks))
+	}
+	for i, want := range []struct {
+		typ     int
+		codecID string
+	}{{TrackVideo, "V_MPEG4/ISO/AVC"}, {TrackAudio, "A_AAC"}, {TrackSubtitle, "S_TEXT/ASS"}} {
+		if tr := info.Tracks[i]; tr.Number != i+1 || tr.Type != want.typ || tr.CodecID != want.codecID {
+			t.Fatalf("第%d个轨道为 %+v", i+1, tr)
+		}
+	}
+	if !info.Tracks[0].Default || !bytes.Equal(info.Tracks[1].Private, []byte{0x12, 0x10}) {
+		t.Fatalf("音视频轨道为 %+v", info.Tracks[:2])
+	}
+
+	// 弹幕字幕默认不显示，CodecPrivate中保留字幕头部和内嵌字体
+	s := info.Tracks[2]
+	if s.Name != "Danmaku" || s.Language != "chi" || s.Default {
+		t.Fatalf("字幕轨道为 %+v", s)
+	}
+	private := string(s.Private)
+	for _, want := range []string{"[Script Info]", "PlayResX: 1920", "Style: R2L,黑体", "[Fonts]\nfontname: danmaku_0.ttf", "[Events]\nFormat: "} {
+		if !strings.Contains(private, want) {
+			t.Fatalf("CodecPrivate中没有 %q:\n%s", want, private)
+		}
+	}
+	if strings.Contains(private, "Dialogue:") {
+		t.Fatal("CodecPrivate中不应包含字幕事件")
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range sub.Events {
+		if !bytes.Contains(b, e.Data) {
+			t.Fatalf("文件中没有字幕事件 %s", e.Data)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, nil, nil, mp4.Tags{}); err == nil {
+		t.Error("没有轨道时应返回错误")
+	}
+	track := testTrack(mp4.Video, 1)
+	track.Codec = "vp09"
+	if err := Write(&bytes.Buffer{}, []*mp4.Track{track}, nil, mp4.Tags{}); err == nil || !strings.Contains(err.Error(), "vp09") {
+		t.Errorf("不支持的编码: %v", err)
+	}
+}
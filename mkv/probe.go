@@ -0,0 +1,277 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"m4s-converter/mp4"
+	"math"
+	"os"
+	"time"
+)
+
+// TrackInfo Matroska文件中的轨道
+type TrackInfo struct {
+	Number   int
+	Type     int // TrackVideo、TrackAudio、TrackSubtitle
+	CodecID  string
+	Name     string
+	Language string
+	Default  bool
+	Private  []byte // CodecPrivate
+}
+
+// Info Probe 读取到的文件信息
+type Info struct {
+	Duration time.Duration
+	Tracks   []TrackInfo
+	Tags     mp4.Tags
+}
+
+// element 文件中的一个元素
+type element struct {
+	id   uint32
+	data int64 // 内容的位置
+	size int64 // 内容长度，未知长度时为-1
+}
+
+func readElement(r io.ReaderAt, off int64) (element, error) {
+	id, n, err := readVint(r, off, false)
+	if err != nil {
+		return element{}, err
+	}
+	size, m, err := readVint(r, off+int64(n), true)
+	if err != nil {
+		return element{}, err
+	}
+	e := element{id: uint32(id), data: off + int64(n+m), size: int64(size)}
+	if size == math.MaxUint64 {
+		e.size = -1
+	}
+	return e, nil
+}
+
+// readChildren 读取[off, end)范围内的元素
+func readChildren(r io.ReaderAt, off, end int64) ([]element, error) {
+	var elems []element
+	for off < end {
+		e, err := readElement(r, off)
+		if err != nil {
+			return nil, err
+		}
+		if e.size < 0 || e.data+e.size > end {
+			return nil, errInvalid
+		}
+		elems = append(elems, e)
+		off = e.data + e.size
+	}
+	return elems, nil
+}
+
+// Probe 读取Matroska文件的轨道、时长和元数据，不读取Cluster
+func Probe(name string) (*Info, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+
+	header, err := readElement(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	if header.id != idEBML || header.size < 0 {
+		return nil, errInvalid
+	}
+	segment, err := readElement(f, header.data+header.size)
+	if err != nil {
+		return nil, err
+	}
+	if segment.id != idSegment {
+		return nil, errInvalid
+	}
+	end := size
+	if segment.size >= 0 {
+		end = min(size, segment.data+segment.size)
+	}
+
+	info := &Info{}
+	scale := float64(time.Millisecond)
+	for off := segment.data; off < end; {
+		e, err := readElement(f, off)
+		if err != nil {
+			return nil, err
+		}
+		if e.size < 0 {
+			break // 未知长度的Cluster之后无法跳过
+		}
+		switch e.id {
+		case idInfo, idTracks, idTags:
+			buf := make([]byte, e.size)
+			if _, err = f.ReadAt(buf, e.data); err != nil {
+				return nil, err
+			}
+			r := bytes.NewReader(buf)
+			switch e.id {
+			case idInfo:
+				err = parseInfo(r, info, &scale)
+			case idTracks:
+				err = parseTracks(r, info)
+			case idTags:
+				err = parseTags(r, info)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		off = e.data + e.size
+	}
+	info.Duration = time.Duration(float64(info.Duration) * scale / float64(time.Millisecond))
+	return info, nil
+}
+
+// ReadTags 读取Matroska文件的全局元数据
+func ReadTags(name string) (mp4.Tags, error) {
+	info, err := Probe(name)
+	if err != nil {
+		return mp4.Tags{}, err
+	}
+	return info.Tags, nil
+}
+
+// parseInfo 时长暂存为以TimestampScale为单位的毫秒数，读取完成后换算
+func parseInfo(r *bytes.Reader, info *Info, scale *float64) error {
+	elems, err := readChildren(r, 0, r.Size())
+	if err != nil {
+		return err
+	}
+	for _, e := range elems {
+		b := data(r, e)
+		switch e.id {
+		case idTimestampScale:
+			*scale = float64(readUint(b))
+		case idDuration:
+			info.Duration = time.Duration(readFloat(b) * float64(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func parseTracks(r *bytes.Reader, info *Info) error {
+	entries, err := readChildren(r, 0, r.Size())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.id != idTrackEntry {
+			continue
+		}
+		elems, err := readChildren(r, entry.data, entry.data+entry.size)
+		if err != nil {
+			return err
+		}
+		t := TrackInfo{Language: "eng", Default: true}
+		for _, e := range elems {
+			b := data(r, e)
+			switch e.id {
+			case idTrackNumber:
+				t.Number = int(readUint(b))
+			case idTrackType:
+				t.Type = int(readUint(b))
+			case idCodecID:
+				t.CodecID = string(b)
+			case idName:
+				t.Name = string(b)
+			case idLanguage:
+				t.Language = string(b)
+			case idFlagDefault:
+				t.Default = readUint(b) != 0
+			case idCodecPrivate:
+				t.Private = b
+			}
+		}
+		info.Tracks = append(info.Tracks, t)
+	}
+	return nil
+}
+
+func parseTags(r *bytes.Reader, info *Info) error {
+	tags, err := readChildren(r, 0, r.Size())
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if tag.id != idTag {
+			continue
+		}
+		elems, err := readChildren(r, tag.data, tag.data+tag.size)
+		if err != nil {
+			return err
+		}
+		for _, e := range elems {
+			if e.id != idSimpleTag {
+				continue
+			}
+			fields, err := readChildren(r, e.data, e.data+e.size)
+			if err != nil {
+				return err
+			}
+			var name, value string
+			for _, v := range fields {
+				switch v.id {
+				case idTagName:
+					name = string(data(r, v))
+				case idTagString:
+					value = string(data(r, v))
+				}
+			}
+			setTag(&info.Tags, name, value)
+		}
+	}
+	return nil
+}
+
+func setTag(tags *mp4.Tags, name, value string) {
+	if name == "" {
+		return
+	}
+	for _, v := range tagNames {
+		if v.name == name {
+			*v.field(tags) = value
+			return
+		}
+	}
+	if tags.Custom == nil {
+		tags.Custom = map[string]string{}
+	}
+	tags.Custom[name] = value
+}
+
+func data(r *bytes.Reader, e element) []byte {
+	b := make([]byte, e.size)
+	_, _ = r.ReadAt(b, e.data)
+	return b
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func readFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
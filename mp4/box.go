@@ -0,0 +1,169 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errShortBox = errors.New("盒子长度不足")
+
+// box 内存中的盒子，Data为不含头部的内容
+type box struct {
+	Type string
+	Data []byte
+}
+
+// header 文件中盒子的位置信息
+type header struct {
+	Type   string
+	Offset int64 // 盒子在文件中的起始位置
+	Size   int64 // 包含头部的总长度
+	Header int64 // 头部长度
+}
+
+// readHeader 读取off处的盒子头部，end为所在容器的结束位置
+func readHeader(r io.ReaderAt, off, end int64) (header, error) {
+	var b [16]byte
+	if _, err := r.ReadAt(b[:8], off); err != nil {
+		return header{}, err
+	}
+	h := header{Type: string(b[4:8]), Offset: off, Header: 8}
+	h.Size = int64(binary.BigEndian.Uint32(b[:4]))
+	switch h.Size {
+	case 0: // 延伸到文件末尾
+		h.Size = end - off
+	case 1: // 64位长度
+		if _, err := r.ReadAt(b[8:16], off+8); err != nil {
+			return header{}, err
+		}
+		h.Size = int64(binary.BigEndian.Uint64(b[8:16]))
+		h.Header = 16
+	}
+	if h.Size < h.Header || off+h.Size > end {
+		return header{}, fmt.Errorf("%s 盒子长度异常: %d", h.Type, h.Size)
+	}
+	return h, nil
+}
+
+// children 解析容器内容中的子盒子
+func children(b []byte) ([]box, error) {
+	var boxes []box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return boxes, errShortBox
+		}
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		hs := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return boxes, errShortBox
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			hs = 16
+		}
+		if size < hs || size > uint64(len(b)) {
+			return boxes, fmt.Errorf("%s 盒子长度异常: %d", typ, size)
+		}
+		boxes = append(boxes, box{Type: typ, Data: b[hs:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// child 返回路径上的第一个子盒子内容，找不到时返回nil
+func child(b []byte, path ...string) []byte {
+	for _, name := range path {
+		boxes, _ := children(b)
+		found := false
+		for _, v := range boxes {
+			if v.Type == name {
+				b, found = v.Data, true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return b
+}
+
+// reader 按大端序读取盒子内容
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.err = errShortBox
+		return make([]byte, n)
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() uint8   { return r.next(1)[0] }
+func (r *reader) u16() uint16 { return binary.BigEndian.Uint16(r.next(2)) }
+func (r *reader) u32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *reader) u64() uint64 { return binary.BigEndian.Uint64(r.next(8)) }
+func (r *reader) skip(n int)  { r.next(n) }
+
+// versionFlags 读取full box的版本和标志
+func (r *reader) versionFlags() (uint8, uint32) {
+	v := r.u32()
+	return uint8(v >> 24), v & 0xFFFFFF
+}
+
+// mkbox 生成盒子，内容超过32位长度时使用64位头部
+func mkbox(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	var b []byte
+	if uint64(n) > 0xFFFFFFFF {
+		b = make([]byte, 0, n+8)
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = append(b, typ...)
+		b = binary.BigEndian.AppendUint64(b, uint64(n+8))
+	} else {
+		b = make([]byte, 0, n)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+		b = append(b, typ...)
+	}
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// mkfull 生成full box
+func mkfull(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	vf := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0xFFFFFF)
+	return mkbox(typ, append([][]byte{vf}, payload...)...)
+}
+
+// writer 按大端序拼接盒子内容
+type writer []byte
+
+func (w *writer) u8(v uint8)      { *w = append(*w, v) }
+func (w *writer) u16(v uint16)    { *w = binary.BigEndian.AppendUint16(*w, v) }
+func (w *writer) u32(v uint32)    { *w = binary.BigEndian.AppendUint32(*w, v) }
+func (w *writer) u64(v uint64)    { *w = binary.BigEndian.AppendUint64(*w, v) }
+func (w *writer) bytes(v ...byte) { *w = append(*w, v...) }
+func (w *writer) str(v string)    { *w = append(*w, v...) }
+func (w *writer) zero(n int)      { *w = append(*w, make([]byte, n)...) }
+func (w *writer) matrix()         { w.u32s(0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000) }
+func (w *writer) u32s(v ...uint32) {
+	for _, x := range v {
+		w.u32(x)
+	}
+}
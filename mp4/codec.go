@@ -0,0 +1,167 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// visualEntryHeader、audioEntryHeader 为样本描述中子盒子之前的固定长度
+const (
+	visualEntryHeader = 78
+	audioEntryHeader  = 28
+)
+
+// entry 返回第一个样本描述的内容
+func (t *Track) entry() []byte {
+	stsd := child(t.SampleEntry, "stsd")
+	if len(stsd) < 8 {
+		return nil
+	}
+	entries, _ := children(stsd[8:])
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0].Data
+}
+
+// CodecBox 返回样本描述中指定子盒子的内容，如 avcC、hvcC、av1C、esds、dfLa、dec3
+func (t *Track) CodecBox(name string) []byte {
+	e := t.entry()
+	skip := audioEntryHeader
+	if t.Handler == Video {
+		skip = visualEntryHeader
+	}
+	if len(e) < skip {
+		return nil
+	}
+	return child(e[skip:], name)
+}
+
+// AudioFormat 返回音频的声道数和采样率
+func (t *Track) AudioFormat() (channels int, sampleRate int) {
+	e := t.entry()
+	if len(e) < audioEntryHeader {
+		return 0, 0
+	}
+	channels = int(binary.BigEndian.Uint16(e[16:]))
+	sampleRate = int(binary.BigEndian.Uint32(e[24:]) >> 16)
+	if sampleRate == 0 {
+		sampleRate = int(t.Timescale)
+	}
+	return
+}
+
+// DecoderConfig 返回esds中的DecoderSpecificInfo，AAC时为AudioSpecificConfig
+func (t *Track) DecoderConfig() []byte {
+	esds := t.CodecBox("esds")
+	if len(esds) < 4 {
+		return nil
+	}
+	return findDescriptor(esds[4:], 0x05)
+}
+
+// findDescriptor 在ES描述符中递归查找指定tag的内容
+func findDescriptor(b []byte, tag byte) []byte {
+	for len(b) > 2 {
+		t := b[0]
+		b = b[1:]
+		size := 0
+		for i := 0; i < 4 && len(b) > 0; i++ {
+			c := b[0]
+			b = b[1:]
+			size = size<<7 | int(c&0x7F)
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		if size > len(b) {
+			return nil
+		}
+		body := b[:size]
+		b = b[size:]
+		switch t {
+		case tag:
+			return body
+		case 0x03: // ES_Descriptor
+			if len(body) < 3 {
+				return nil
+			}
+			flags := body[2]
+			body = body[3:]
+			if flags&0x80 != 0 && len(body) >= 2 {
+				body = body[2:]
+			}
+			if flags&0x40 != 0 && len(body) >= 1 {
+				if 1+int(body[0]) > len(body) {
+					return nil
+				}
+				body = body[1+int(body[0]):]
+			}
+			if flags&0x20 != 0 && len(body) >= 2 {
+				body = body[2:]
+			}
+			if v := findDescriptor(body, tag); v != nil {
+				return v
+			}
+		case 0x04: // DecoderConfigDescriptor
+			if len(body) < 13 {
+				return nil
+			}
+			if v := findDescriptor(body[13:], tag); v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// AVCSampleEntry 生成H.264视频的样本描述（stsd），avcC为AVCDecoderConfigurationRecord
+func AVCSampleEntry(width, height uint32, avcC []byte) []byte {
+	w := writer{}
+	w.zero(6)
+	w.u16(1) // data_reference_index
+	w.zero(16)
+	w.u16(uint16(width))
+	w.u16(uint16(height))
+	w.u32(0x00480000) // 72 dpi
+	w.u32(0x00480000)
+	w.u32(0)
+	w.u16(1)   // frame_count
+	w.zero(32) // compressorname
+	w.u16(0x0018)
+	w.u16(0xFFFF)
+	return mkfull("stsd", 0, 0, be32(1), mkbox("avc1", w, mkbox("avcC", avcC)))
+}
+
+// AACSampleEntry 生成AAC音频的样本描述（stsd），config为AudioSpecificConfig
+func AACSampleEntry(channels, sampleRate int, config []byte) []byte {
+	w := writer{}
+	w.zero(6)
+	w.u16(1) // data_reference_index
+	w.zero(8)
+	w.u16(uint16(channels))
+	w.u16(16) // samplesize
+	w.zero(4)
+	w.u32(uint32(sampleRate) << 16)
+
+	dsi := descriptor(0x05, config)
+	dc := writer{}
+	dc.u8(0x40)        // objectTypeIndication: MPEG-4 Audio
+	dc.u8(0x05<<2 | 1) // streamType: AudioStream
+	dc.zero(3)         // bufferSizeDB
+	dc.u32(0)          // maxBitrate
+	dc.u32(0)          // avgBitrate
+	es := writer{}
+	es.u16(0) // ES_ID
+	es.u8(0)
+	es.bytes(descriptor(0x04, append(dc, dsi...))...)
+	es.bytes(descriptor(0x06, []byte{0x02})...)
+	esds := mkfull("esds", 0, 0, descriptor(0x03, es))
+	return mkfull("stsd", 0, 0, be32(1), mkbox("mp4a", w, esds))
+}
+
+// descriptor 生成ES描述符，长度固定使用4字节编码
+func descriptor(tag byte, body []byte) []byte {
+	n := len(body)
+	b := []byte{tag, byte(n>>21)&0x7F | 0x80, byte(n>>14)&0x7F | 0x80, byte(n>>7)&0x7F | 0x80, byte(n) & 0x7F}
+	return append(b, body...)
+}
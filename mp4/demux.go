@@ -0,0 +1,530 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// 轨道类型
+const (
+	Video    = "vide"
+	Audio    = "soun"
+	Text     = "text"
+	Subtitle = "sbtl"
+)
+
+// Sample 轨道中的一帧数据
+type Sample struct {
+	Offset   int64  // 数据在源文件中的位置
+	Size     uint32 // 数据长度
+	Duration uint32 // 持续时间，单位为轨道时间刻度
+	CTO      int32  // 显示时间与解码时间的差值
+	Sync     bool   // 是否为关键帧
+}
+
+// Track 音视频轨道
+type Track struct {
+	ID          uint32
+	Handler     string // vide、soun 等
+	Name        string // hdlr中的轨道名称
+	Timescale   uint32
+	Language    string // ISO-639-2/T 语言代码
+	Width       uint32
+	Height      uint32
+	Codec       string // 第一个样本描述的类型，如 avc1、hev1、mp4a、fLaC、ec-3
+	SampleEntry []byte // 完整的stsd盒子
+	MediaTime   int64  // 编辑列表的起始时间，-1表示没有编辑列表
+	Samples     []Sample
+	Source      io.ReaderAt // 样本数据所在的文件
+	Disabled    bool        // 轨道默认不启用
+}
+
+// Duration 轨道时长，单位为轨道时间刻度
+func (t *Track) Duration() uint64 {
+	var d uint64
+	for _, s := range t.Samples {
+		d += uint64(s.Duration)
+	}
+	return d
+}
+
+// Time 轨道时长
+func (t *Track) Time() time.Duration {
+	if t.Timescale == 0 {
+		return 0
+	}
+	return time.Duration(t.Duration() * uint64(time.Second) / uint64(t.Timescale))
+}
+
+// Size 样本数据总长度
+func (t *Track) Size() int64 {
+	var n int64
+	for _, s := range t.Samples {
+		n += int64(s.Size)
+	}
+	return n
+}
+
+// File 已解析的MP4文件，支持分片（DASH）和非分片两种结构
+type File struct {
+	Tracks     []*Track
+	Tags       Tags
+	Fragmented bool
+	f          *os.File
+}
+
+// Open 打开并解析MP4文件
+func Open(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	file, err := Parse(f, st.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	file.f = f
+	return file, nil
+}
+
+// Close 关闭Open打开的文件
+func (m *File) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
+
+// Track 返回第一个指定类型的轨道，找不到时返回nil
+func (m *File) Track(handler string) *Track {
+	for _, t := range m.Tracks {
+		if t.Handler == handler {
+			return t
+		}
+	}
+	return nil
+}
+
+// trex 分片中样本的默认值
+type trex struct {
+	duration uint32
+	size     uint32
+	flags    uint32
+}
+
+// Parse 从r中解析MP4结构，size为数据总长度
+func Parse(r io.ReaderAt, size int64) (*File, error) {
+	var moov []byte
+	var moofs []int64
+	for off := int64(0); off < size; {
+		h, err := readHeader(r, off, size)
+		if err != nil {
+			return nil, err
+		}
+		switch h.Type {
+		case "moov":
+			moov = make([]byte, h.Size-h.Header)
+			if _, err = r.ReadAt(moov, off+h.Header); err != nil {
+				return nil, err
+			}
+		case "moof":
+			moofs = append(moofs, off)
+		}
+		off += h.Size
+	}
+	if moov == nil {
+		return nil, errors.New("找不到moov盒子")
+	}
+
+	m := &File{}
+	defaults := map[uint32]trex{}
+	boxes, err := children(moov)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range boxes {
+		switch b.Type {
+		case "trak":
+			t, err := parseTrak(b.Data)
+			if err != nil {
+				return nil, err
+			}
+			t.Source = r
+			m.Tracks = append(m.Tracks, t)
+		case "mvex":
+			exs, _ := children(b.Data)
+			for _, ex := range exs {
+				if ex.Type != "trex" {
+					continue
+				}
+				rd := &reader{b: ex.Data}
+				rd.versionFlags()
+				id := rd.u32()
+				rd.u32() // default_sample_description_index
+				defaults[id] = trex{duration: rd.u32(), size: rd.u32(), flags: rd.u32()}
+			}
+		case "udta":
+			m.Tags = parseUdta(b.Data)
+		}
+	}
+
+	for _, off := range moofs {
+		m.Fragmented = true
+		h, err := readHeader(r, off, size)
+		if err != nil {
+			return nil, err
+		}
+		moof := make([]byte, h.Size-h.Header)
+		if _, err = r.ReadAt(moof, off+h.Header); err != nil {
+			return nil, err
+		}
+		if err = m.parseMoof(moof, off, size, defaults); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func parseTrak(trak []byte) (*Track, error) {
+	t := &Track{MediaTime: -1, Language: "und"}
+
+	rd := &reader{b: child(trak, "tkhd")}
+	v, flags := rd.versionFlags()
+	t.Disabled = flags&1 == 0
+	if v == 1 {
+		rd.skip(16)
+		t.ID = rd.u32()
+		rd.skip(12)
+	} else {
+		rd.skip(8)
+		t.ID = rd.u32()
+		rd.skip(8)
+	}
+	rd.skip(8 + 8 + 36)
+	t.Width, t.Height = rd.u32()>>16, rd.u32()>>16
+	if rd.err != nil {
+		return nil, errors.New("tkhd 解析失败")
+	}
+
+	if elst := child(trak, "edts", "elst"); elst != nil {
+		rd = &reader{b: elst}
+		v, _ = rd.versionFlags()
+		for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+			var mt int64
+			if v == 1 {
+				rd.u64()
+				mt = int64(rd.u64())
+			} else {
+				rd.u32()
+				mt = int64(int32(rd.u32()))
+			}
+			rd.u32() // media_rate
+			if mt >= 0 {
+				t.MediaTime = mt
+				break
+			}
+		}
+	}
+
+	mdia := child(trak, "mdia")
+	rd = &reader{b: child(mdia, "mdhd")}
+	if v, _ = rd.versionFlags(); v == 1 {
+		rd.skip(16)
+		t.Timescale = rd.u32()
+		rd.u64()
+	} else {
+		rd.skip(8)
+		t.Timescale = rd.u32()
+		rd.u32()
+	}
+	t.Language = unpackLanguage(rd.u16())
+	if rd.err != nil || t.Timescale == 0 {
+		return nil, errors.New("mdhd 解析失败")
+	}
+
+	rd = &reader{b: child(mdia, "hdlr")}
+	rd.versionFlags()
+	rd.u32()
+	t.Handler = string(rd.next(4))
+	rd.skip(12)
+	if rd.err == nil {
+		t.Name = cstring(rd.b)
+	}
+
+	stbl := child(mdia, "minf", "stbl")
+	stsd := child(stbl, "stsd")
+	if len(stsd) < 8 {
+		return nil, errors.New("找不到stsd盒子")
+	}
+	t.SampleEntry = mkbox("stsd", stsd)
+	if entries, _ := children(stsd[8:]); len(entries) > 0 {
+		t.Codec = entries[0].Type
+	}
+	return t, parseStbl(t, stbl)
+}
+
+// parseStbl 解析非分片文件的样本表
+func parseStbl(t *Track, stbl []byte) error {
+	rd := &reader{b: child(stbl, "stsz")}
+	rd.versionFlags()
+	fixed, count := rd.u32(), rd.u32()
+	if rd.err != nil || count == 0 {
+		return nil
+	}
+	// 样本数来自文件，分配前按box的实际长度检查：逐个记录大小时每个样本占4字节，
+	// 固定大小时不能超过stts中的样本总数
+	if fixed == 0 && uint64(count) > uint64(len(rd.b))/4 || fixed != 0 && uint64(count) > sttsCount(stbl) {
+		return fmt.Errorf("轨道%d的样本数%d超出样本表的长度", t.ID, count)
+	}
+	t.Samples = make([]Sample, count)
+	for i := range t.Samples {
+		t.Samples[i].Sync = true
+		if fixed != 0 {
+			t.Samples[i].Size = fixed
+		} else {
+			t.Samples[i].Size = rd.u32()
+		}
+	}
+
+	rd = &reader{b: child(stbl, "stts")}
+	rd.versionFlags()
+	i := 0
+	for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+		c, d := rd.u32(), rd.u32()
+		for ; c > 0 && i < len(t.Samples); c-- {
+			t.Samples[i].Duration = d
+			i++
+		}
+	}
+
+	if ctts := child(stbl, "ctts"); ctts != nil {
+		rd = &reader{b: ctts}
+		rd.versionFlags()
+		i = 0
+		for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+			c, o := rd.u32(), int32(rd.u32())
+			for ; c > 0 && i < len(t.Samples); c-- {
+				t.Samples[i].CTO = o
+				i++
+			}
+		}
+	}
+
+	if stss := child(stbl, "stss"); stss != nil {
+		for i := range t.Samples {
+			t.Samples[i].Sync = false
+		}
+		rd = &reader{b: stss}
+		rd.versionFlags()
+		for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+			if k := int(rd.u32()) - 1; k >= 0 && k < len(t.Samples) {
+				t.Samples[k].Sync = true
+			}
+		}
+	}
+
+	var offsets []int64
+	if stco := child(stbl, "stco"); stco != nil {
+		rd = &reader{b: stco}
+		rd.versionFlags()
+		for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+			offsets = append(offsets, int64(rd.u32()))
+		}
+	} else if co64 := child(stbl, "co64"); co64 != nil {
+		rd = &reader{b: co64}
+		rd.versionFlags()
+		for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+			offsets = append(offsets, int64(rd.u64()))
+		}
+	}
+
+	type stscEntry struct{ first, count uint32 }
+	var stsc []stscEntry
+	rd = &reader{b: child(stbl, "stsc")}
+	rd.versionFlags()
+	for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+		stsc = append(stsc, stscEntry{first: rd.u32(), count: rd.u32()})
+		rd.u32()
+	}
+	i = 0
+	for k, e := range stsc {
+		last := uint32(len(offsets))
+		if k+1 < len(stsc) {
+			last = stsc[k+1].first - 1
+		}
+		for c := e.first; c <= last && int(c) <= len(offsets); c++ {
+			off := offsets[c-1]
+			for j := uint32(0); j < e.count && i < len(t.Samples); j++ {
+				t.Samples[i].Offset = off
+				off += int64(t.Samples[i].Size)
+				i++
+			}
+		}
+	}
+	if i != len(t.Samples) {
+		return fmt.Errorf("轨道%d样本表不完整", t.ID)
+	}
+	return nil
+}
+
+// sttsCount stts中的样本总数
+func sttsCount(stbl []byte) uint64 {
+	rd := &reader{b: child(stbl, "stts")}
+	rd.versionFlags()
+	var total uint64
+	for n := rd.u32(); n > 0 && rd.err == nil; n-- {
+		total += uint64(rd.u32())
+		rd.u32()
+	}
+	return total
+}
+
+// parseMoof 解析分片中的样本，off为moof在文件中的位置，size为文件长度
+func (m *File) parseMoof(moof []byte, off, size int64, defaults map[uint32]trex) error {
+	boxes, err := children(moof)
+	if err != nil {
+		return err
+	}
+	// 没有base-data-offset时，第一个traf的数据从moof开始，之后的traf接着上一个traf的数据
+	end := off
+	for _, b := range boxes {
+		if b.Type != "traf" {
+			continue
+		}
+		rd := &reader{b: child(b.Data, "tfhd")}
+		_, flags := rd.versionFlags()
+		id := rd.u32()
+		var t *Track
+		for _, v := range m.Tracks {
+			if v.ID == id {
+				t = v
+			}
+		}
+		if t == nil {
+			return fmt.Errorf("分片引用了不存在的轨道%d", id)
+		}
+		def := defaults[id]
+		base := end
+		if flags&0x1 != 0 {
+			base = int64(rd.u64())
+		} else if flags&0x20000 != 0 { // default-base-is-moof
+			base = off
+		}
+		if flags&0x2 != 0 {
+			rd.u32()
+		}
+		if flags&0x8 != 0 {
+			def.duration = rd.u32()
+		}
+		if flags&0x10 != 0 {
+			def.size = rd.u32()
+		}
+		if flags&0x20 != 0 {
+			def.flags = rd.u32()
+		}
+		if rd.err != nil {
+			return errors.New("tfhd 解析失败")
+		}
+
+		// 没有data_offset的trun接着上一个trun的数据，第一个从base开始
+		pos := base
+		runs, _ := children(b.Data)
+		for _, run := range runs {
+			if run.Type != "trun" {
+				continue
+			}
+			if pos, err = t.parseTrun(run.Data, base, pos, size, def); err != nil {
+				return err
+			}
+		}
+		end = pos
+	}
+	return nil
+}
+
+// parseTrun 解析trun中的样本，pos为没有data_offset时数据的起始位置，返回数据的结束位置
+func (t *Track) parseTrun(trun []byte, base, pos, size int64, def trex) (int64, error) {
+	rd := &reader{b: trun}
+	_, flags := rd.versionFlags()
+	count := rd.u32()
+	if flags&0x1 != 0 {
+		pos = base + int64(int32(rd.u32()))
+	}
+	firstFlags, hasFirst := uint32(0), flags&0x4 != 0
+	if hasFirst {
+		firstFlags = rd.u32()
+	}
+	// 样本数来自文件，每个样本的字段必须在box内，没有逐样本字段时数据必须在文件内
+	perSample := 0
+	for _, f := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&f != 0 {
+			perSample += 4
+		}
+	}
+	if perSample > 0 && uint64(count) > uint64(len(rd.b)/perSample) ||
+		perSample == 0 && count > 0 && (def.size == 0 || uint64(count)*uint64(def.size) > uint64(max(size-pos, 0))) {
+		return pos, fmt.Errorf("trun 样本数%d超出长度", count)
+	}
+	t.Samples = slices.Grow(t.Samples, int(count))
+	for i := uint32(0); i < count; i++ {
+		s := Sample{Offset: pos, Duration: def.duration, Size: def.size}
+		sf := def.flags
+		if i == 0 && hasFirst {
+			sf = firstFlags
+		}
+		if flags&0x100 != 0 {
+			s.Duration = rd.u32()
+		}
+		if flags&0x200 != 0 {
+			s.Size = rd.u32()
+		}
+		if flags&0x400 != 0 {
+			sf = rd.u32()
+		}
+		if flags&0x800 != 0 {
+			// 版本0为无符号数，但常见封装器也会写入负值，统一按有符号处理
+			s.CTO = int32(rd.u32())
+		}
+		if rd.err != nil {
+			return pos, errors.New("trun 解析失败")
+		}
+		s.Sync = sf&0x10000 == 0
+		pos += int64(s.Size)
+		t.Samples = append(t.Samples, s)
+	}
+	return pos, nil
+}
+
+func unpackLanguage(v uint16) string {
+	if v == 0 || v == 0x7FFF {
+		return "und"
+	}
+	return string([]byte{byte(v>>10&0x1F) + 0x60, byte(v>>5&0x1F) + 0x60, byte(v&0x1F) + 0x60})
+}
+
+func packLanguage(s string) uint16 {
+	if len(s) != 3 {
+		s = "und"
+	}
+	return uint16(s[0]-0x60)<<10 | uint16(s[1]-0x60)<<5 | uint16(s[2]-0x60)
+}
+
+// cstring 读取以0结尾的字符串
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
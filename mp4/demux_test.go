@@ -0,0 +1,236 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testTrack 生成样本数据连续存放在内存中的轨道，每个样本的内容为其序号
+func testTrack(handler, codec string, n int) *Track {
+	t := &Track{
+		Handler:     handler,
+		Timescale:   1000,
+		Language:    "und",
+		Codec:       codec,
+		SampleEntry: mkfull("stsd", 0, 0, be32(1), mkbox(codec, make([]byte, 28))),
+		MediaTime:   -1,
+	}
+	if handler == Video {
+		t.Width, t.Height = 640, 360
+	}
+	var data []byte
+	for i := 0; i < n; i++ {
+		size := 10 + i%7
+		s := Sample{Offset: int64(len(data)), Size: uint32(size), Duration: 40, Sync: handler != Video || i%3 == 0}
+		if handler == Video {
+			s.CTO = int32(i%3-1) * 40
+		}
+		data = append(data, bytes.Repeat([]byte{byte(i)}, size)...)
+		t.Samples = append(t.Samples, s)
+	}
+	t.Source = bytes.NewReader(data)
+	return t
+}
+
+// sampleData 读取轨道中所有样本的数据
+func sampleData(t *testing.T, tr *Track) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, s := range tr.Samples {
+		b := make([]byte, s.Size)
+		if _, err := tr.Source.ReadAt(b, s.Offset); err != nil {
+			t.Fatalf("读取样本失败: %v", err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func compareTracks(t *testing.T, want, got *Track) {
+	t.Helper()
+	if got.Handler != want.Handler || got.Codec != want.Codec || got.Timescale != want.Timescale || got.Width != want.Width {
+		t.Fatalf("轨道信息不一致: %+v", got)
+	}
+	if len(got.Samples) != len(want.Samples) {
+		t.Fatalf("样本数 %d, 期望 %d", len(got.Samples), len(want.Samples))
+	}
+	for i, s := range got.Samples {
+		w := want.Samples[i]
+		if s.Size != w.Size || s.Duration != w.Duration || s.CTO != w.CTO || s.Sync != w.Sync {
+			t.Fatalf("样本%d为 %+v, 期望 %+v", i, s, w)
+		}
+	}
+	gd, wd := sampleData(t, got), sampleData(t, want)
+	for i := range gd {
+		if !bytes.Equal(gd[i], wd[i]) {
+			t.Fatalf("样本%d的数据不一致", i)
+		}
+	}
+}
+
+func TestParseWriteRoundTrip(t *testing.T) {
+	tracks := []*Track{testTrack(Video, "avc1", 50), testTrack(Audio, "mp4a", 80)}
+	tags := Tags{Title: "标题", Artist: "UP主"}
+
+	var first bytes.Buffer
+	if err := Write(&first, tracks, tags); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(bytes.NewReader(first.Bytes()), int64(first.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Fragmented || len(m.Tracks) != 2 {
+		t.Fatalf("解析结果: fragmented=%v tracks=%d", m.Fragmented, len(m.Tracks))
+	}
+	for i := range tracks {
+		compareTracks(t, tracks[i], m.Tracks[i])
+	}
+	if m.Tags.Title != tags.Title || m.Tags.Artist != tags.Artist {
+		t.Fatalf("元数据为 %+v", m.Tags)
+	}
+
+	// 再次写入的结果应完全相同
+	var second bytes.Buffer
+	if err = Write(&second, m.Tracks, m.Tags); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("重新封装的文件与第一次不同")
+	}
+	m2, err := Parse(bytes.NewReader(second.Bytes()), int64(second.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tracks {
+		compareTracks(t, tracks[i], m2.Tracks[i])
+	}
+}
+
+// fragmentedInit 生成带trex的初始化段，轨道不含样本
+func fragmentedInit(tracks []*Track) []byte {
+	empty := make([]*Track, len(tracks))
+	for i, tr := range tracks {
+		c := *tr
+		c.Samples = nil
+		empty[i] = &c
+	}
+	moov := buildMoov(empty, nil, false, Tags{})
+	var payload [][]byte
+	boxes, _ := children(moov[8:])
+	for _, b := range boxes {
+		payload = append(payload, mkbox(b.Type, b.Data))
+	}
+	var trex [][]byte
+	for i := range tracks {
+		trex = append(trex, mkfull("trex", 0, 0, be32(uint32(i+1)), be32(1), be32(40), be32(0), be32(0)))
+	}
+	payload = append(payload, mkbox("mvex", trex...))
+	return append(mkbox("ftyp", []byte("iso6"), be32(0)), mkbox("moov", payload...)...)
+}
+
+// trun 生成逐个记录样本大小的trun，dataOffset小于0时不写入data_offset
+func trun(sizes []uint32, dataOffset int32) []byte {
+	w := writer{}
+	w.u32(uint32(len(sizes)))
+	flags := uint32(0x200)
+	if dataOffset >= 0 {
+		flags |= 0x1
+		w.u32(uint32(dataOffset))
+	}
+	for _, s := range sizes {
+		w.u32(s)
+	}
+	return mkfull("trun", 0, flags, w)
+}
+
+func TestParseFragments(t *testing.T) {
+	tracks := []*Track{testTrack(Video, "avc1", 6), testTrack(Audio, "mp4a", 3)}
+	sizes := func(tr *Track, from, to int) []uint32 {
+		var v []uint32
+		for _, s := range tr.Samples[from:to] {
+			v = append(v, s.Size)
+		}
+		return v
+	}
+	init := fragmentedInit(tracks)
+
+	// 第一个traf有两个trun，第二个没有data_offset；第二个traf没有base-data-offset，
+	// 数据应接在第一个traf之后
+	build := func(dataOffset int32) []byte {
+		traf1 := mkbox("traf", mkfull("tfhd", 0, 0, be32(1)),
+			trun(sizes(tracks[0], 0, 3), dataOffset), trun(sizes(tracks[0], 3, 6), -1))
+		traf2 := mkbox("traf", mkfull("tfhd", 0, 0, be32(2)), trun(sizes(tracks[1], 0, 3), -1))
+		return mkbox("moof", mkfull("mfhd", 0, 0, be32(1)), traf1, traf2)
+	}
+	moof := build(int32(len(build(0)) + 8))
+
+	var mdat []byte
+	for _, tr := range tracks {
+		for _, d := range sampleData(t, tr) {
+			mdat = append(mdat, d...)
+		}
+	}
+	file := append(append(init, moof...), mkbox("mdat", mdat)...)
+
+	m, err := Parse(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Fragmented {
+		t.Fatal("应识别为分片文件")
+	}
+	for i := range tracks {
+		got := m.Tracks[i]
+		if len(got.Samples) != len(tracks[i].Samples) {
+			t.Fatalf("轨道%d样本数 %d", i, len(got.Samples))
+		}
+		want := sampleData(t, tracks[i])
+		for j, d := range sampleData(t, got) {
+			if !bytes.Equal(d, want[j]) {
+				t.Fatalf("轨道%d样本%d的数据不一致，位置%d", i, j, got.Samples[j].Offset)
+			}
+		}
+	}
+
+	// 分片文件重新封装后与原始轨道一致
+	var out bytes.Buffer
+	if err = Write(&out, m.Tracks, Tags{}); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Parse(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tracks {
+		if d := sampleData(t, m2.Tracks[i]); !bytes.Equal(bytes.Join(d, nil), bytes.Join(sampleData(t, tracks[i]), nil)) {
+			t.Fatalf("轨道%d重新封装后数据不一致", i)
+		}
+	}
+}
+
+func TestParseRejectsHugeSampleCount(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Track{testTrack(Video, "avc1", 3)}, Tags{}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	i := bytes.Index(b, []byte("stsz"))
+	if i < 0 {
+		t.Fatal("找不到stsz")
+	}
+	// stsz: 类型之后为version/flags、sample_size、sample_count
+	binary.BigEndian.PutUint32(b[i+12:], 0xFFFFFFFF)
+	if _, err := Parse(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Fatal("样本数超出stsz长度时应返回错误")
+	}
+
+	tracks := []*Track{testTrack(Audio, "mp4a", 1)}
+	huge := mkfull("trun", 0, 0x200, be32(0xFFFFFFFF), be32(10))
+	moof := mkbox("moof", mkfull("mfhd", 0, 0, be32(1)), mkbox("traf", mkfull("tfhd", 0, 0, be32(1)), huge))
+	file := append(fragmentedInit(tracks), moof...)
+	if _, err := Parse(bytes.NewReader(file), int64(len(file))); err == nil {
+		t.Fatal("样本数超出trun长度时应返回错误")
+	}
+}
@@ -0,0 +1,355 @@
+package mp4
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// movieTimescale 输出文件mvhd和tkhd使用的时间刻度
+const movieTimescale = 1000
+
+// chunkDuration 交错写入时每个chunk的最长时长，单位为秒
+const chunkDuration = 0.5
+
+// tagPadding moov之后预留的free空间，便于WriteTags原地修改元数据
+const tagPadding = 4096
+
+// chunk 输出文件中连续存放的一组样本
+type chunk struct {
+	track  int
+	first  int // 第一个样本的序号
+	count  int
+	start  float64 // 起始解码时间，单位为秒
+	size   int64
+	offset int64 // 在输出文件中的位置
+}
+
+// WriteFile 将轨道合成为非分片的MP4文件
+func WriteFile(name string, tracks []*Track, tags Tags) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = Write(f, tracks, tags); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write 将轨道按 ftyp、moov、mdat 的顺序写入w，moov前置便于边下边播
+func Write(w io.Writer, tracks []*Track, tags Tags) error {
+	if len(tracks) == 0 {
+		return errors.New("没有可写入的轨道")
+	}
+	chunks := interleave(tracks)
+	var payload int64
+	for _, c := range chunks {
+		payload += c.size
+	}
+
+	ftyp := mkbox("ftyp", []byte("isom"), []byte{0, 0, 2, 0}, []byte("isomiso2mp41"))
+	free := mkbox("free", make([]byte, tagPadding-8))
+	mdatHeader := mkboxHeader("mdat", payload)
+
+	// chunk偏移不影响moov长度，先计算长度再填入实际偏移，文件超过4GB时使用co64
+	moov := buildMoov(tracks, chunks, false, tags)
+	large := int64(len(ftyp)+len(moov)+len(free)+len(mdatHeader))+payload > 0xFFFFFFFF
+	if large {
+		moov = buildMoov(tracks, chunks, true, tags)
+	}
+	base := int64(len(ftyp) + len(moov) + len(free) + len(mdatHeader))
+	for i := range chunks {
+		chunks[i].offset = base
+		base += chunks[i].size
+	}
+	moov = buildMoov(tracks, chunks, large, tags)
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	for _, b := range [][]byte{ftyp, moov, free, mdatHeader} {
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, c := range chunks {
+		if err := copySamples(bw, tracks[c.track], c.first, c.count); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// mkboxHeader 生成内容长度为n的盒子头部
+func mkboxHeader(typ string, n int64) []byte {
+	w := writer{}
+	if n+8 > 0xFFFFFFFF {
+		w.u32(1)
+		w.str(typ)
+		w.u64(uint64(n + 16))
+	} else {
+		w.u32(uint32(n + 8))
+		w.str(typ)
+	}
+	return w
+}
+
+// copySamples 复制连续的样本，源文件中相邻的样本合并为一次读取
+func copySamples(w io.Writer, t *Track, first, count int) error {
+	samples := t.Samples[first : first+count]
+	for i := 0; i < len(samples); {
+		off, n := samples[i].Offset, int64(samples[i].Size)
+		j := i + 1
+		for ; j < len(samples) && samples[j].Offset == off+n; j++ {
+			n += int64(samples[j].Size)
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(t.Source, off, n)); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// interleave 按解码时间交错排列各轨道的chunk
+func interleave(tracks []*Track) []chunk {
+	var chunks []chunk
+	for ti, t := range tracks {
+		limit := uint64(chunkDuration * float64(t.Timescale))
+		var dts, elapsed uint64
+		for i, s := range t.Samples {
+			if i == 0 || elapsed >= limit {
+				chunks = append(chunks, chunk{
+					track: ti, first: i, start: float64(dts) / float64(t.Timescale)})
+				elapsed = 0
+			}
+			c := &chunks[len(chunks)-1]
+			c.count++
+			c.size += int64(s.Size)
+			dts += uint64(s.Duration)
+			elapsed += uint64(s.Duration)
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].start < chunks[j].start
+	})
+	return chunks
+}
+
+func buildMoov(tracks []*Track, chunks []chunk, large bool, tags Tags) []byte {
+	var movieDuration uint64
+	var traks [][]byte
+	for i, t := range tracks {
+		d := scale(t.Duration(), t.Timescale)
+		if d > movieDuration {
+			movieDuration = d
+		}
+		var own []chunk
+		for _, c := range chunks {
+			if c.track == i {
+				own = append(own, c)
+			}
+		}
+		traks = append(traks, buildTrak(t, uint32(i+1), own, large))
+	}
+
+	w := writer{}
+	w.zero(8) // creation_time, modification_time
+	w.u32(movieTimescale)
+	w.u32(uint32(movieDuration))
+	w.u32(0x00010000) // rate
+	w.u16(0x0100)     // volume
+	w.zero(10)
+	w.matrix()
+	w.zero(24)
+	w.u32(uint32(len(tracks) + 1)) // next_track_ID
+	payload := [][]byte{mkfull("mvhd", 0, 0, w)}
+	payload = append(payload, traks...)
+	if udta := buildUdta(tags); udta != nil {
+		payload = append(payload, udta)
+	}
+	return mkbox("moov", payload...)
+}
+
+// scale 将轨道时间刻度的时长换算为输出文件的时间刻度
+func scale(d uint64, timescale uint32) uint64 {
+	return d * movieTimescale / uint64(timescale)
+}
+
+func buildTrak(t *Track, id uint32, chunks []chunk, large bool) []byte {
+	duration := t.Duration()
+
+	w := writer{}
+	w.zero(8)
+	w.u32(id)
+	w.zero(4)
+	w.u32(uint32(scale(duration, t.Timescale)))
+	w.zero(8)
+	w.u16(0) // layer
+	if t.Handler == Audio {
+		w.u16(1)      // alternate_group
+		w.u16(0x0100) // volume
+	} else {
+		w.u16(0)
+		w.u16(0)
+	}
+	w.zero(2)
+	w.matrix()
+	w.u32(t.Width << 16)
+	w.u32(t.Height << 16)
+	flags := uint32(0x3) // track_enabled | track_in_movie
+	if t.Disabled {
+		flags = 0x2
+	}
+	payload := [][]byte{mkfull("tkhd", 0, flags, w)}
+
+	if t.MediaTime >= 0 {
+		e := writer{}
+		e.u32(1)
+		e.u32(uint32(scale(duration-min(duration, uint64(t.MediaTime)), t.Timescale)))
+		e.u32(uint32(t.MediaTime))
+		e.u32(0x00010000)
+		payload = append(payload, mkbox("edts", mkfull("elst", 0, 0, e)))
+	}
+
+	w = writer{}
+	w.zero(8)
+	w.u32(t.Timescale)
+	w.u32(uint32(duration))
+	w.u16(packLanguage(t.Language))
+	w.u16(0)
+	mdhd := mkfull("mdhd", 0, 0, w)
+
+	w = writer{}
+	w.u32(0)
+	w.str(t.Handler)
+	w.zero(12)
+	w.str(t.Name)
+	w.u8(0)
+	hdlr := mkfull("hdlr", 0, 0, w)
+
+	var mhd []byte
+	switch t.Handler {
+	case Video:
+		mhd = mkfull("vmhd", 0, 1, make([]byte, 8))
+	case Audio:
+		mhd = mkfull("smhd", 0, 0, make([]byte, 4))
+	case Subtitle:
+		// ISO 14496-30 的字幕使用sthd，3GPP的tx3g使用nmhd
+		if t.Codec != "tx3g" {
+			mhd = mkfull("sthd", 0, 0)
+			break
+		}
+		fallthrough
+	default:
+		mhd = mkfull("nmhd", 0, 0)
+	}
+	dref := mkfull("dref", 0, 0, []byte{0, 0, 0, 1}, mkfull("url ", 0, 1))
+	minf := mkbox("minf", mhd, mkbox("dinf", dref), buildStbl(t, chunks, large))
+	payload = append(payload, mkbox("mdia", mdhd, hdlr, minf))
+	return mkbox("trak", payload...)
+}
+
+func buildStbl(t *Track, chunks []chunk, large bool) []byte {
+	payload := [][]byte{t.SampleEntry}
+
+	// stts 按持续时间合并
+	w := writer{}
+	var n uint32
+	for i := 0; i < len(t.Samples); {
+		j := i + 1
+		for j < len(t.Samples) && t.Samples[j].Duration == t.Samples[i].Duration {
+			j++
+		}
+		w.u32(uint32(j - i))
+		w.u32(t.Samples[i].Duration)
+		n++
+		i = j
+	}
+	payload = append(payload, mkfull("stts", 0, 0, be32(n), w))
+
+	// ctts 仅在存在显示时间偏移时写入
+	hasCTO, negative := false, false
+	for _, s := range t.Samples {
+		hasCTO = hasCTO || s.CTO != 0
+		negative = negative || s.CTO < 0
+	}
+	if hasCTO {
+		w, n = writer{}, 0
+		for i := 0; i < len(t.Samples); {
+			j := i + 1
+			for j < len(t.Samples) && t.Samples[j].CTO == t.Samples[i].CTO {
+				j++
+			}
+			w.u32(uint32(j - i))
+			w.u32(uint32(t.Samples[i].CTO))
+			n++
+			i = j
+		}
+		version := uint8(0)
+		if negative {
+			version = 1
+		}
+		payload = append(payload, mkfull("ctts", version, 0, be32(n), w))
+	}
+
+	// stss 全部为关键帧时省略
+	w, n = writer{}, 0
+	for i, s := range t.Samples {
+		if s.Sync {
+			w.u32(uint32(i + 1))
+			n++
+		}
+	}
+	if int(n) != len(t.Samples) {
+		payload = append(payload, mkfull("stss", 0, 0, be32(n), w))
+	}
+
+	// stsc 每个chunk的样本数变化时新增一项
+	w, n = writer{}, 0
+	for i, c := range chunks {
+		if i == 0 || c.count != chunks[i-1].count {
+			w.u32(uint32(i + 1))
+			w.u32(uint32(c.count))
+			w.u32(1)
+			n++
+		}
+	}
+	payload = append(payload, mkfull("stsc", 0, 0, be32(n), w))
+
+	w = writer{}
+	w.u32(0)
+	w.u32(uint32(len(t.Samples)))
+	for _, s := range t.Samples {
+		w.u32(s.Size)
+	}
+	payload = append(payload, mkfull("stsz", 0, 0, w))
+
+	w = writer{}
+	w.u32(uint32(len(chunks)))
+	for _, c := range chunks {
+		if large {
+			w.u64(uint64(c.offset))
+		} else {
+			w.u32(uint32(c.offset))
+		}
+	}
+	if large {
+		payload = append(payload, mkfull("co64", 0, 0, w))
+	} else {
+		payload = append(payload, mkfull("stco", 0, 0, w))
+	}
+	return mkbox("stbl", payload...)
+}
+
+func be32(v uint32) []byte {
+	w := writer{}
+	w.u32(v)
+	return w
+}
@@ -0,0 +1,352 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// CustomMean 自定义元数据（---- 条目）使用的命名空间
+const CustomMean = "com.bilibili"
+
+// Tags moov/udta中的元数据，对应MP4Box的-tags和-cprt参数写入的内容
+type Tags struct {
+	Title     string            // ©nam
+	Artist    string            // ©ART
+	Album     string            // ©alb
+	Comment   string            // ©cmt
+	Date      string            // ©day
+	Copyright string            // udta/cprt
+	Cover     []byte            // covr，JPEG或PNG
+	Custom    map[string]string // ---- 条目，键为name
+}
+
+// String 以“键: 值”逐行输出非空的元数据
+func (t Tags) String() string {
+	var b strings.Builder
+	for _, v := range []struct{ k, v string }{
+		{"title", t.Title}, {"artist", t.Artist}, {"album", t.Album},
+		{"comment", t.Comment}, {"date", t.Date}, {"copyright", t.Copyright},
+	} {
+		if v.v != "" {
+			fmt.Fprintf(&b, "%s: %s\n", v.k, v.v)
+		}
+	}
+	keys := make([]string, 0, len(t.Custom))
+	for k := range t.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, t.Custom[k])
+	}
+	return b.String()
+}
+
+// ReadTags 只读取文件的moov盒子并返回其中的元数据，不解析样本
+func ReadTags(name string) (Tags, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Tags{}, err
+	}
+	moov, err := readMoov(f, st.Size())
+	if err != nil {
+		return Tags{}, err
+	}
+	return parseUdta(child(moov, "udta")), nil
+}
+
+// readMoov 读取顶层的moov盒子内容
+func readMoov(r io.ReaderAt, size int64) ([]byte, error) {
+	for off := int64(0); off < size; {
+		h, err := readHeader(r, off, size)
+		if err != nil {
+			return nil, err
+		}
+		if h.Type == "moov" {
+			moov := make([]byte, h.Size-h.Header)
+			if _, err = r.ReadAt(moov, off+h.Header); err != nil {
+				return nil, err
+			}
+			return moov, nil
+		}
+		off += h.Size
+	}
+	return nil, errors.New("找不到moov盒子")
+}
+
+// parseUdta 解析udta中的cprt和meta/ilst
+func parseUdta(udta []byte) Tags {
+	var t Tags
+	if cprt := child(udta, "cprt"); len(cprt) > 6 {
+		t.Copyright = cstring(cprt[6:])
+	}
+	meta := child(udta, "meta")
+	// ISO的meta为full box，QuickTime的meta直接包含子盒子
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	items, _ := children(child(meta, "ilst"))
+	for _, item := range items {
+		if item.Type == "----" {
+			name, value := parseFreeform(item.Data)
+			if name != "" {
+				if t.Custom == nil {
+					t.Custom = map[string]string{}
+				}
+				t.Custom[name] = value
+			}
+			continue
+		}
+		data := child(item.Data, "data")
+		if len(data) < 8 {
+			continue
+		}
+		typ, value := binary.BigEndian.Uint32(data)&0xFFFFFF, data[8:]
+		switch item.Type {
+		case "\xa9nam":
+			t.Title = dataString(typ, value)
+		case "\xa9ART":
+			t.Artist = dataString(typ, value)
+		case "\xa9alb":
+			t.Album = dataString(typ, value)
+		case "\xa9cmt":
+			t.Comment = dataString(typ, value)
+		case "\xa9day":
+			t.Date = dataString(typ, value)
+		case "cprt":
+			t.Copyright = dataString(typ, value)
+		case "covr":
+			t.Cover = bytes.Clone(value)
+		}
+	}
+	return t
+}
+
+// parseFreeform 解析 ----（mean/name/data）条目
+func parseFreeform(b []byte) (name, value string) {
+	boxes, _ := children(b)
+	for _, v := range boxes {
+		switch v.Type {
+		case "name":
+			if len(v.Data) >= 4 {
+				name = string(v.Data[4:])
+			}
+		case "data":
+			if len(v.Data) >= 8 {
+				value = dataString(binary.BigEndian.Uint32(v.Data)&0xFFFFFF, v.Data[8:])
+			}
+		}
+	}
+	return
+}
+
+// dataString 按data盒子的类型转换为字符串
+func dataString(typ uint32, b []byte) string {
+	switch typ {
+	case 2: // UTF-16BE
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(u))
+	case 21: // 有符号整数
+		var v int64
+		for _, c := range b {
+			v = v<<8 | int64(c)
+		}
+		if len(b) > 0 && len(b) < 8 && b[0]&0x80 != 0 {
+			v -= 1 << (8 * len(b))
+		}
+		return strconv.FormatInt(v, 10)
+	default:
+		return string(b)
+	}
+}
+
+// buildUdta 生成iTunes风格的元数据，与MP4Box的-tags和-cprt参数写入的结构一致
+func buildUdta(tags Tags) []byte {
+	var items [][]byte
+	for _, v := range []struct{ typ, value string }{
+		{"\xa9nam", tags.Title},
+		{"\xa9ART", tags.Artist},
+		{"\xa9alb", tags.Album},
+		{"\xa9cmt", tags.Comment},
+		{"\xa9day", tags.Date},
+	} {
+		if v.value != "" {
+			items = append(items, ilstItem(v.typ, 1, []byte(v.value)))
+		}
+	}
+	if len(tags.Cover) > 0 {
+		typ := uint32(13) // JPEG
+		if bytes.HasPrefix(tags.Cover, []byte("\x89PNG")) {
+			typ = 14
+		}
+		items = append(items, ilstItem("covr", typ, tags.Cover))
+	}
+	keys := make([]string, 0, len(tags.Custom))
+	for k := range tags.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w := writer{}
+		w.u32(1)
+		w.u32(0)
+		w.str(tags.Custom[k])
+		items = append(items, mkbox("----",
+			mkfull("mean", 0, 0, []byte(CustomMean)),
+			mkfull("name", 0, 0, []byte(k)),
+			mkbox("data", w)))
+	}
+
+	var payload [][]byte
+	if items != nil {
+		w := writer{}
+		w.u32(0)
+		w.str("mdir")
+		w.str("appl")
+		w.zero(9)
+		hdlr := mkfull("hdlr", 0, 0, w)
+		payload = append(payload, mkfull("meta", 0, 0, hdlr, mkbox("ilst", items...)))
+	}
+	if tags.Copyright != "" {
+		w := writer{}
+		w.u16(packLanguage("und"))
+		w.str(tags.Copyright)
+		w.u8(0)
+		payload = append(payload, mkfull("cprt", 0, 0, w))
+	}
+	if payload == nil {
+		return nil
+	}
+	return mkbox("udta", payload...)
+}
+
+// ilstItem 生成ilst条目，typ为data盒子的数据类型
+func ilstItem(typ string, dataType uint32, value []byte) []byte {
+	w := writer{}
+	w.u32(dataType)
+	w.u32(0) // locale
+	w.bytes(value...)
+	return mkbox(typ, mkbox("data", w))
+}
+
+// WriteTags 修改文件中的元数据。moov位于文件末尾或其后有足够的free空间时原地修改，否则重新封装整个文件
+func WriteTags(name string, tags Tags) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+
+	var moovH, freeH header
+	for off := int64(0); off < size; {
+		h, err := readHeader(f, off, size)
+		if err != nil {
+			return err
+		}
+		if h.Type == "moov" {
+			moovH = h
+		} else if moovH.Type != "" && off == moovH.Offset+moovH.Size && (h.Type == "free" || h.Type == "skip") {
+			freeH = h
+		}
+		off += h.Size
+	}
+	if moovH.Type == "" {
+		return errors.New("找不到moov盒子")
+	}
+	moov := make([]byte, moovH.Size-moovH.Header)
+	if _, err = f.ReadAt(moov, moovH.Offset+moovH.Header); err != nil {
+		return err
+	}
+	newMoov, err := replaceUdta(moov, tags)
+	if err != nil {
+		return err
+	}
+
+	n, avail := int64(len(newMoov)), moovH.Size+freeH.Size
+	switch {
+	case moovH.Offset+moovH.Size == size: // moov在末尾，修改长度不影响样本位置
+		if _, err = f.WriteAt(newMoov, moovH.Offset); err != nil {
+			return err
+		}
+		if err = f.Truncate(moovH.Offset + n); err != nil {
+			return err
+		}
+	case n == avail || n+8 <= avail: // 使用moov后的free空间
+		buf := newMoov
+		if n < avail {
+			buf = append(buf, mkbox("free", make([]byte, avail-n-8))...)
+		}
+		if _, err = f.WriteAt(buf, moovH.Offset); err != nil {
+			return err
+		}
+	default:
+		return rewrite(f, name, size, tags)
+	}
+	return f.Sync()
+}
+
+// replaceUdta 替换moov中的元数据，保留udta中的其它盒子
+func replaceUdta(moov []byte, tags Tags) ([]byte, error) {
+	boxes, err := children(moov)
+	if err != nil {
+		return nil, err
+	}
+	var payload [][]byte
+	var extra [][]byte
+	for _, b := range boxes {
+		if b.Type != "udta" {
+			payload = append(payload, mkbox(b.Type, b.Data))
+			continue
+		}
+		items, _ := children(b.Data)
+		for _, v := range items {
+			if v.Type != "meta" && v.Type != "cprt" {
+				extra = append(extra, mkbox(v.Type, v.Data))
+			}
+		}
+	}
+	udta := buildUdta(tags)
+	if udta != nil {
+		extra = append(extra, udta[8:])
+	}
+	if extra != nil {
+		payload = append(payload, mkbox("udta", extra...))
+	}
+	return mkbox("moov", payload...), nil
+}
+
+// rewrite 重新封装文件并写入元数据，用于原地修改空间不足的情况
+func rewrite(f *os.File, name string, size int64, tags Tags) error {
+	m, err := Parse(f, size)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tags"
+	if err = WriteFile(tmp, m.Tracks, tags); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = f.Close()
+	return os.Rename(tmp, name)
+}
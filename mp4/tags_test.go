@@ -0,0 +1,198 @@
+package mp4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeMoovLast 按 ftyp、mdat、moov 的顺序写入文件，模拟moov位于末尾的文件
+func writeMoovLast(t *testing.T, name string, tracks []*Track, tags Tags) {
+	t.Helper()
+	ftyp := mkbox("ftyp", []byte("isom"), be32(0x200))
+	chunks := interleave(tracks)
+	var mdat bytes.Buffer
+	base := int64(len(ftyp) + 8)
+	for i, c := range chunks {
+		chunks[i].offset = base + int64(mdat.Len())
+		if err := copySamples(&mdat, tracks[c.track], c.first, c.count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	moov := buildMoov(tracks, chunks, false, tags)
+	b := append(append(ftyp, mkbox("mdat", mdat.Bytes())...), moov...)
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkFile 检查文件中的元数据，以及样本数据是否仍与原始轨道一致
+func checkFile(t *testing.T, name string, tracks []*Track, want Tags) {
+	t.Helper()
+	got, err := ReadTags(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("元数据为 %+v, 期望 %+v", got, want)
+	}
+	m, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := range tracks {
+		compareTracks(t, tracks[i], m.Tracks[i])
+	}
+}
+
+func testTags() Tags {
+	return Tags{
+		Title:     "标题",
+		Artist:    "UP主",
+		Album:     "合集",
+		Comment:   "简介",
+		Date:      "2024-01-02",
+		Copyright: "版权",
+		Custom:    map[string]string{"bvid": "BV1xx411c7mD", "cid": "123"},
+	}
+}
+
+// topLevel 文件中顶层盒子的类型
+func topLevel(t *testing.T, name string) []string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := children(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, v := range boxes {
+		types = append(types, v.Type)
+	}
+	return types
+}
+
+func TestWriteTags(t *testing.T) {
+	tracks := []*Track{testTrack(Video, "avc1", 30), testTrack(Audio, "mp4a", 40)}
+	cover := append([]byte("\x89PNG"), bytes.Repeat([]byte{1}, 2*tagPadding)...)
+	faststart := func(tags Tags) func(t *testing.T, name string) {
+		return func(t *testing.T, name string) {
+			if err := WriteFile(name, tracks, tags); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		create   func(t *testing.T, name string)
+		tags     Tags
+		layout   []string
+		sameSize bool // 原地修改时文件长度不变
+	}{
+		{
+			name:   "moov在末尾",
+			create: func(t *testing.T, name string) { writeMoovLast(t, name, tracks, Tags{Title: "旧标题"}) },
+			tags:   Tags{Title: "新标题", Cover: cover},
+			layout: []string{"ftyp", "mdat", "moov"},
+		},
+		{
+			name:     "使用moov之后的free空间",
+			create:   faststart(Tags{Title: "旧标题"}),
+			tags:     testTags(),
+			layout:   []string{"ftyp", "moov", "free", "mdat"},
+			sameSize: true,
+		},
+		{
+			name:     "清空元数据",
+			create:   faststart(testTags()),
+			tags:     Tags{},
+			layout:   []string{"ftyp", "moov", "free", "mdat"},
+			sameSize: true,
+		},
+		{
+			name:   "空间不足时重新封装",
+			create: faststart(Tags{Title: "旧标题"}),
+			tags:   Tags{Title: "新标题", Cover: cover},
+			layout: []string{"ftyp", "moov", "free", "mdat"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "a.mp4")
+			tt.create(t, name)
+			before, err := os.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = WriteTags(name, tt.tags); err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, name, tracks, tt.tags)
+			if got := topLevel(t, name); !reflect.DeepEqual(got, tt.layout) {
+				t.Fatalf("文件结构为 %v, 期望 %v", got, tt.layout)
+			}
+			after, err := os.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (after.Size() == before.Size()) != tt.sameSize {
+				t.Fatalf("文件长度由%d变为%d", before.Size(), after.Size())
+			}
+			if _, err = os.Stat(name + ".tags"); !os.IsNotExist(err) {
+				t.Fatal("重新封装的临时文件未删除")
+			}
+		})
+	}
+}
+
+// testdata/quicktime_meta.mp4 的udta中的meta不是full box，直接包含hdlr和ilst，另有一个 ©too 条目
+func TestQuickTimeMeta(t *testing.T) {
+	const fixture = "testdata/quicktime_meta.mp4"
+	got, err := ReadTags(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "QuickTime标题" || got.Artist != "QuickTime作者" {
+		t.Fatalf("元数据为 %+v", got)
+	}
+
+	name := filepath.Join(t.TempDir(), "a.mp4")
+	b, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tags := Tags{Title: "新标题"}
+	if err = WriteTags(name, tags); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ReadTags(name); err != nil || !reflect.DeepEqual(got, tags) {
+		t.Fatalf("元数据为 %+v, %v", got, err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	st, _ := f.Stat()
+	moov, err := readMoov(f, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := children(child(moov, "udta"))
+	var types []string
+	for _, v := range items {
+		types = append(types, v.Type)
+	}
+	if !reflect.DeepEqual(types, []string{"\xa9too", "meta"}) {
+		t.Fatalf("udta中的盒子为 %q", types)
+	}
+}
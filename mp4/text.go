@@ -0,0 +1,92 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// textTimescale 文本轨道的时间刻度
+const textTimescale = 1000
+
+// TextCue 一条定时文本
+type TextCue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// NewTextTrack 生成3GPP timed text（tx3g）字幕轨道，cues需按时间排序且互不重叠，
+// 空白时间写入空样本。width、height为文本区域大小，通常与视频相同
+func NewTextTrack(cues []TextCue, name, language string, width, height uint32) *Track {
+	t := &Track{
+		Handler:     Subtitle,
+		Name:        name,
+		Timescale:   textTimescale,
+		Language:    language,
+		Width:       width,
+		Height:      height,
+		Codec:       "tx3g",
+		SampleEntry: tx3gEntry(width, height),
+		MediaTime:   -1,
+	}
+	var data bytes.Buffer
+	add := func(text string, duration int64) {
+		if duration <= 0 {
+			return
+		}
+		// 文本过长时截断，样本中的文本长度为16位
+		if len(text) > 0xFFFF {
+			text = strings.ToValidUTF8(text[:0xFFFF], "")
+		}
+		t.Samples = append(t.Samples, Sample{
+			Offset:   int64(data.Len()),
+			Size:     uint32(2 + len(text)),
+			Duration: uint32(duration),
+			Sync:     true,
+		})
+		_ = binary.Write(&data, binary.BigEndian, uint16(len(text)))
+		data.WriteString(text)
+	}
+	var pos int64
+	for _, c := range cues {
+		start, end := c.Start.Milliseconds(), c.End.Milliseconds()
+		if start < pos {
+			start = pos
+		}
+		add("", start-pos)
+		add(c.Text, end-start)
+		pos = max(pos, end)
+	}
+	t.Source = bytes.NewReader(data.Bytes())
+	return t
+}
+
+// tx3gEntry 生成tx3g样本描述，文字白色居中显示在底部
+func tx3gEntry(width, height uint32) []byte {
+	w := writer{}
+	w.zero(6)
+	w.u16(1)          // data_reference_index
+	w.u32(0)          // displayFlags
+	w.u8(1)           // horizontal-justification 居中
+	w.u8(0xFF)        // vertical-justification 底部
+	w.u32(0x00000000) // background-color-rgba
+	w.u16(0)          // BoxRecord top
+	w.u16(0)          // left
+	w.u16(uint16(height))
+	w.u16(uint16(width))
+	w.u16(0) // StyleRecord startChar
+	w.u16(0) // endChar
+	w.u16(1) // font-ID
+	w.u8(0)  // face-style-flags
+	w.u8(18) // font-size
+	w.u32(0xFFFFFFFF)
+	f := writer{}
+	f.u16(1)
+	f.u16(1)
+	f.u8(uint8(len("Sans-Serif")))
+	f.str("Sans-Serif")
+	w.bytes(mkbox("ftab", f)...)
+	return mkfull("stsd", 0, 0, be32(1), mkbox("tx3g", w))
+}
@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	Android客户端缓存目录结构（tv.danmaku.bili/download）：
+	<avid>/c_<cid>/entry.json           普通视频，每个分P一个目录
+	s_<season_id>/<ep_id>/entry.json    番剧，每集一个目录，部分版本为ep<id>
+	.../danmaku.xml                     弹幕
+	.../<type_tag>/video.m4s、audio.m4s 清晰度目录，如 16、32、64、80、112、116
+	.../<type_tag>/0.blv、1.blv……       旧版客户端的分段flv，type_tag如 lua.flv.bili2api.80
+*/
+
+// AndroidQualityDir 返回条目目录下entry.json的type_tag对应的清晰度目录，
+// type_tag对应的目录不存在时，选择数字最大的包含音视频文件的目录
+func AndroidQualityDir(dir string, entry *bilicache.AndroidEntry) string {
+	if entry.TypeTag != "" {
+		if d := filepath.Join(dir, entry.TypeTag); isDir(d) {
+			return d
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && hasAndroidMedia(filepath.Join(dir, e.Name())) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	if len(dirs) == 0 {
+		return ""
+	}
+	slices.SortFunc(dirs, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	return filepath.Join(dir, dirs[len(dirs)-1])
+}
+
+// hasAndroidMedia 目录中是否有m4s、blv或已修复的音视频文件
+func hasAndroidMedia(dir string) bool {
+	entries, _ := os.ReadDir(dir)
+	return slices.ContainsFunc(entries, func(e os.DirEntry) bool {
+		ext := filepath.Ext(e.Name())
+		return !e.IsDir() && (ext == conver.M4sSuffix || ext == conver.BlvSuffix || ext == conver.Mp4Suffix)
+	})
+}
+
+// hasM4s 目录中是否有m4s文件
+func hasM4s(dir string) bool {
+	entries, _ := os.ReadDir(dir)
+	return slices.ContainsFunc(entries, func(e os.DirEntry) bool {
+		return !e.IsDir() && filepath.Ext(e.Name()) == conver.M4sSuffix
+	})
+}
+
+// androidSelected 清晰度目录所属的条目已缓存完成，且是entry.json选中的清晰度
+func androidSelected(quality string) bool {
+	dir := filepath.Dir(quality)
+	entry, err := bilicache.ReadAndroidEntry(filepath.Join(dir, conver.PlayEntryJson))
+	if err != nil {
+		logrus.Warn("找不到.playurl和entry.json文件，跳过: ", quality)
+		return false
+	}
+	if meta := entry.Meta(); !meta.Completed() {
+		logrus.Warn("跳过未缓存完成的视频: ", dir)
+		return false
+	}
+	if AndroidQualityDir(dir, entry) != quality {
+		logrus.Info("跳过未选择的清晰度目录: ", quality)
+		return false
+	}
+	return true
+}
+
+// androidItem 解析Android客户端的条目目录
+func (s *Scanner) androidItem(ctx context.Context, dir, info string) (*Item, error) {
+	entry, err := bilicache.ReadAndroidEntry(info)
+	if err != nil {
+		return nil, err
+	}
+	meta := entry.Meta()
+	if !meta.Completed() {
+		return newItem(dir, info, "", "", meta), nil // 由合成流程记为跳过
+	}
+	quality := AndroidQualityDir(dir, entry)
+	if quality == "" {
+		return nil, fmt.Errorf("找不到音视频所在的清晰度目录: %s", dir)
+	}
+	if filepath.Base(quality) != entry.TypeTag {
+		logrus.Warnf("找不到type_tag(%s)对应的清晰度目录，使用: %s", entry.TypeTag, quality)
+	}
+	video, audio, err := s.audioAndVideo(quality)
+	if err != nil {
+		return nil, err
+	}
+	item := newItem(dir, info, video, audio, meta)
+	if !s.AssOFF && !s.DryRun {
+		item.AssPath = downloadXml(ctx, filepath.Join(dir, conver.DanmakuXml), entry.Cid())
+	}
+	return item, nil
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
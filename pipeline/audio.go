@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"m4s-converter/flac"
+	"m4s-converter/mp4"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	utils "github.com/mzky/utils/common"
+	"github.com/sirupsen/logrus"
+)
+
+// 导出音频的扩展名
+const (
+	M4aSuffix  = ".m4a"
+	FlacSuffix = ".flac"
+)
+
+// indexAudio 导出的音频在索引中的类型
+const indexAudio = "audio"
+
+// audioExt 按编码选择导出音频的容器：AAC和杜比音频使用M4A（MP4），无损音频使用FLAC
+func audioExt(codec string) (string, error) {
+	switch codec {
+	case "mp4a", "ec-3", "ac-3":
+		return M4aSuffix, nil
+	case "fLaC":
+		return FlacSuffix, nil
+	}
+	return "", fmt.Errorf("不支持导出的音频编码: %s", codec)
+}
+
+// ExportAudio 将条目的音频流重新封装为独立的音频文件，并写入标题、UP主和封面
+func (p *Pipeline) ExportAudio(ctx context.Context, item *Item, outputDir string) ItemResult {
+	r, run := p.prepareAudio(item, outputDir, nil)
+	if run == nil {
+		return r
+	}
+	return run(ctx, nil)
+}
+
+// prepareAudio 判断条目是否需要导出音频，需要时返回执行导出的函数
+func (p *Pipeline) prepareAudio(item *Item, outputDir string, c *claims) (ItemResult, func(context.Context, func(float64)) ItemResult) {
+	r := ItemResult{Item: item, Status: StatusSkipped}
+	if !item.Completed() {
+		logrus.Warn("未缓存完成,跳过导出音频", item.Dir, item.Title+"-"+item.Uname)
+		r.Reason = "未缓存完成"
+		return r, nil
+	}
+	if existing, ok := p.index.Unchanged(item, indexAudio, p.OutputFile(item, outputDir, "")); ok {
+		logrus.Warn("跳过未变化的已导出音频: ", existing)
+		r.Output, r.Reason = existing, "已导出"
+		c.claim(existing, "")
+		return r, nil
+	}
+	t, closer, err := p.audioTrack(item)
+	if err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return r, nil
+	}
+	ext, err := audioExt(t.Codec)
+	_ = closer.Close()
+	if err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return r, nil
+	}
+	r.Output = p.OutputFile(item, outputDir, ext)
+
+	groupDir := filepath.Dir(r.Output)
+	if !utils.IsExist(groupDir) && !p.DryRun {
+		if err = os.MkdirAll(groupDir, os.ModePerm); err != nil {
+			r.Status, r.Err = StatusFailed, fmt.Errorf("无法创建目录：%s", groupDir)
+			return r, nil
+		}
+	}
+	if utils.IsExist(r.Output) || c.has(r.Output) {
+		logrus.Warn("跳过已导出的音频: ", r.Output)
+		r.Reason = "已导出"
+		return r, nil
+	}
+	c.claim(r.Output, "")
+	if p.DryRun {
+		r.Status = StatusPlanned
+		return r, nil
+	}
+	return r, func(ctx context.Context, progress func(float64)) ItemResult {
+		return p.writeAudio(ctx, r, ext, progress)
+	}
+}
+
+// audioTrack 读取条目的音频轨道，返回的Closer由调用方关闭
+func (p *Pipeline) audioTrack(item *Item) (*mp4.Track, io.Closer, error) {
+	tracks, c, err := p.Scanner.OpenTracks(item.Audio)
+	if err != nil {
+		return nil, nil, fmt.Errorf("探测文件失败: %s: %v", item.Audio, err)
+	}
+	i := slices.IndexFunc(tracks, func(t *mp4.Track) bool { return t.Handler == mp4.Audio })
+	if i < 0 {
+		_ = c.Close()
+		return nil, nil, fmt.Errorf("文件中找不到%s流: %s", StreamAudio, item.Audio)
+	}
+	return tracks[i], c, nil
+}
+
+// writeAudio 写入音频文件和元数据，失败时删除输出文件
+func (p *Pipeline) writeAudio(ctx context.Context, r ItemResult, ext string, progress func(float64)) ItemResult {
+	if err := ctx.Err(); err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return r
+	}
+	t, closer, err := p.audioTrack(r.Item)
+	if err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return r
+	}
+	defer closer.Close()
+
+	tags := r.Item.AudioTags()
+	tags.Cover = loadCover(ctx, r.Item.Cover)
+	var written func(n, total int64)
+	if progress != nil {
+		written = func(n, total int64) { progress(percentOf(n, total)) }
+	}
+	err = createFile(ctx, r.Output, Size(r.Item.Audio), written, func(w io.Writer) error {
+		if ext == FlacSuffix {
+			return flac.Write(w, t, tags)
+		}
+		return mp4.Write(w, []*mp4.Track{t}, tags)
+	})
+	if err == nil {
+		err = verifyAudio(r.Output)
+	}
+	if err != nil {
+		_ = os.Remove(r.Output)
+		logrus.Errorf("%s 导出失败", filepath.Base(r.Output))
+		r.Status, r.Err = StatusFailed, err
+		return r
+	}
+	logrus.Info("已导出音频文件:", r.Output)
+	p.index.Put(r.Item, indexAudio, r.Output, "")
+	r.Status = StatusConverted
+	return r
+}
+
+// verifyAudio 校验导出的音频文件
+func verifyAudio(file string) error {
+	if filepath.Ext(file) == FlacSuffix {
+		_, err := flac.ReadTags(file)
+		return err
+	}
+	streams, err := probeMP4(file)
+	if err != nil {
+		return err
+	}
+	if !hasStream(streams, StreamAudio) {
+		return errors.New("导出的文件缺少音频流")
+	}
+	return nil
+}
+
+// loadCover 读取封面图片，cover可以是本地路径或URL，失败时不写入封面
+func loadCover(ctx context.Context, cover string) []byte {
+	if cover == "" {
+		return nil
+	}
+	if !strings.HasPrefix(cover, "http://") && !strings.HasPrefix(cover, "https://") && !strings.HasPrefix(cover, "//") {
+		b, err := os.ReadFile(cover)
+		if err != nil {
+			logrus.Warn("读取封面失败: ", err)
+			return nil
+		}
+		return b
+	}
+	if strings.HasPrefix(cover, "//") {
+		cover = "https:" + cover
+	}
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cover, nil)
+	if err != nil {
+		logrus.Warn("下载封面失败: ", err)
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Warn("下载封面失败: ", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warn("下载封面失败: ", resp.Status)
+		return nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logrus.Warn("下载封面失败: ", err)
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"m4s-converter/flv"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HasBlvFiles 目录中是否有旧版Android客户端的.blv分段
+func HasBlvFiles(dir string) bool {
+	entries, _ := os.ReadDir(dir)
+	return slices.ContainsFunc(entries, func(e os.DirEntry) bool {
+		return !e.IsDir() && filepath.Ext(e.Name()) == conver.BlvSuffix
+	})
+}
+
+// BlvSegments 按index.json的分段顺序返回.blv文件，没有index.json时按文件名中的序号排序
+func BlvSegments(dir string) ([]string, error) {
+	if idx, err := bilicache.ReadBlvIndex(filepath.Join(dir, bilicache.IndexJson)); err == nil && len(idx.SegmentList) > 0 {
+		var files []string
+		for i := range idx.SegmentList {
+			file := filepath.Join(dir, strconv.Itoa(i)+conver.BlvSuffix)
+			if Size(file) == 0 {
+				return nil, fmt.Errorf("分段不完整，找不到: %s", file)
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type segment struct {
+		n    int
+		file string
+	}
+	var segments []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != conver.BlvSuffix {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(name, conver.BlvSuffix))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{n, filepath.Join(dir, name)})
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("找不到blv文件: %s", dir)
+	}
+	slices.SortFunc(segments, func(a, b segment) int { return a.n - b.n })
+	files := make([]string, len(segments))
+	for i, s := range segments {
+		files[i] = s.file
+	}
+	return files, nil
+}
+
+// blvIntermediates 返回.blv分段拆分出的视频和音频中间文件
+func blvIntermediates(dir string) ([]*intermediate, error) {
+	segments, err := BlvSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := splitFLV(segments, nil, filepath.Join(dir, "video"), filepath.Join(dir, "audio"))
+	if allFresh(files) {
+		return files, nil
+	}
+	f, err := flv.Open(segments...)
+	if err != nil {
+		return nil, err
+	}
+	complete := f.Video != nil && f.Audio != nil
+	_ = f.Close()
+	if !complete {
+		return nil, fmt.Errorf("blv分段中缺少音频或视频: %s", dir)
+	}
+	return files, nil
+}
+
+// splitFLV 拼接flv分段，视频和音频分别写入一个中间文件。section不为空时用于去掉分段头部的额外字节
+func splitFLV(segments []string, section func(*os.File) (*io.SectionReader, error), videoBase, audioBase string) []*intermediate {
+	open := func(video bool) func() ([]*mp4.Track, io.Closer, error) {
+		return func() ([]*mp4.Track, io.Closer, error) {
+			var files fileList
+			var readers []*io.SectionReader
+			for _, name := range segments {
+				f, err := os.Open(name)
+				if err != nil {
+					_ = files.Close()
+					return nil, nil, err
+				}
+				files = append(files, f)
+				st, err := f.Stat()
+				if err != nil {
+					_ = files.Close()
+					return nil, nil, err
+				}
+				r := io.NewSectionReader(f, 0, st.Size())
+				if section != nil {
+					if r, err = section(f); err != nil {
+						_ = files.Close()
+						return nil, nil, err
+					}
+				}
+				readers = append(readers, r)
+			}
+			f, err := flv.Parse(readers...)
+			if err != nil {
+				_ = files.Close()
+				return nil, nil, err
+			}
+			t := f.Audio
+			if video {
+				t = f.Video
+			}
+			return []*mp4.Track{t}, files, nil
+		}
+	}
+	return []*intermediate{
+		{dst: videoBase + conver.VideoSuffix, tracks: open(true), sources: segments},
+		{dst: audioBase + conver.AudioSuffix, tracks: open(false), sources: segments},
+	}
+}
+
+// fileList 一组需要一起关闭的文件
+type fileList []*os.File
+
+func (l fileList) Close() error {
+	var err error
+	for _, f := range l {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// FindBlvFiles 将Android客户端选中清晰度目录中的.blv分段转换为音视频文件
+func (s *Scanner) FindBlvFiles(ctx context.Context, dir string) error {
+	if !androidSelected(dir) {
+		return nil
+	}
+	files, err := blvIntermediates(dir)
+	if err == nil && allFresh(files) {
+		return nil
+	}
+	logrus.Info("拼接blv分段: ", strings.TrimPrefix(dir, s.CachePath))
+	if err == nil {
+		for _, m := range files {
+			if err = s.produce(ctx, m); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// 旧缓存中损坏的分段较常见，跳过该目录继续转换其他目录
+		logrus.Errorf("%v 转换异常：%v", dir, err)
+		return nil
+	}
+	if s.DryRun {
+		return nil
+	}
+	logrus.Info("已将blv转换为音视频文件: ", strings.TrimPrefix(files[0].dst, s.CachePath))
+	return nil
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"m4s-converter/bilicache"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlvSegments(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0.blv", "1.blv", "2.blv", "10.blv", "9.blv", "cover.blv", "entry.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("FLV"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 没有index.json时按序号排序，10.blv在9.blv之后，忽略不是序号的文件
+	got, err := BlvSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0.blv", "1.blv", "2.blv", "9.blv", "10.blv"}
+	if !reflect.DeepEqual(baseNames(got), want) {
+		t.Fatalf("分段为 %v", baseNames(got))
+	}
+	if !HasBlvFiles(dir) {
+		t.Fatal("应识别为blv目录")
+	}
+
+	// index.json中的分段数决定使用哪些分段
+	index := filepath.Join(dir, bilicache.IndexJson)
+	if err = os.WriteFile(index, []byte(`{"segment_list":[{"duration":1000},{"duration":1000},{"duration":500}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = BlvSegments(dir); err != nil || !reflect.DeepEqual(baseNames(got), want[:3]) {
+		t.Fatalf("分段为 %v: %v", baseNames(got), err)
+	}
+	if err = os.Remove(filepath.Join(dir, "1.blv")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = BlvSegments(dir); err == nil {
+		t.Fatal("缺少分段时应返回错误")
+	}
+	if _, err = BlvSegments(t.TempDir()); err == nil {
+		t.Fatal("没有分段时应返回错误")
+	}
+}
+
+// baseNames 文件名列表
+func baseNames(files []string) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
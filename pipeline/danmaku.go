@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"compress/flate"
+	"context"
+	"io"
+	"m4s-converter/conver"
+	"m4s-converter/internal"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// downloadFile 下载文件，先写入同目录的临时文件，ctx取消或下载失败时不会留下不完整的文件
+func downloadFile(ctx context.Context, url string, filepath string) error {
+	// 创建带超时的HTTP客户端
+	client := &http.Client{
+		Timeout: 3 * time.Second, // 3秒超时
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// 发起HTTP GET请求
+	httpReq, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "HTTP请求失败")
+	}
+	defer httpReq.Body.Close()
+
+	if httpReq.StatusCode != http.StatusOK {
+		return errors.New("无法获取字幕数据")
+	}
+
+	// 写入本地文件
+	return internal.WriteFile(filepath, func(localFile *os.File) error {
+		// 检查Content-Encoding是否为deflate
+		contentEncoding := httpReq.Header.Get("Content-Encoding")
+		if contentEncoding == "deflate" {
+			// 如果是deflate编码，解压缩数据
+			reader := flate.NewReader(httpReq.Body)
+			defer reader.Close()
+
+			// 读取并解压数据
+			bodyBytes, err := io.ReadAll(reader)
+			if err != nil || bodyBytes == nil {
+				return errors.New("无法获取字幕数据")
+			}
+
+			// 将解压后的数据写入本地文件
+			_, err = localFile.Write(bodyBytes)
+			return err
+		}
+		// 如果不是deflate编码，直接将响应体写入文件
+		_, err := io.Copy(localFile, httpReq.Body)
+		return err
+	})
+}
+
+func joinUrl(cid string) string {
+	return "https://comment.bilibili.com/" + cid + conver.XmlSuffix
+}
+func joinXmlUrl(cid string) string {
+	return "https://api.bilibili.com/x/v1/dm/list.so?oid=" + cid
+}
+
+// downloadXml 将xml弹幕转换为ass格式，本地没有xml文件时按cid下载，返回ass文件路径
+func downloadXml(ctx context.Context, xmlPath, cid string) string {
+	if Size(xmlPath) != 0 {
+		return conver.Xml2Ass(xmlPath) // 转换xml弹幕文件为ass格式
+	}
+	if cid == "" {
+		return ""
+	}
+	if e := downloadFile(ctx, joinUrl(cid), xmlPath); e != nil {
+		if downloadFile(ctx, joinXmlUrl(cid), xmlPath) != nil {
+			logrus.Warn("弹幕文件下载失败:", joinUrl(cid))
+			return ""
+		}
+	}
+	return conver.Xml2Ass(xmlPath) // 转换xml弹幕文件为ass格式
+}
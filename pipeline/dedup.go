@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"m4s-converter/conver"
+	"m4s-converter/internal"
+	"m4s-converter/mkv"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utils "github.com/mzky/utils/common"
+	"github.com/sirupsen/logrus"
+)
+
+// matchTags 元数据是否属于该条目，优先比较自定义元数据
+func matchTags(tags mp4.Tags, item *Item) bool {
+	if id, ok := tags.Custom["itemId"]; ok {
+		return id == item.ItemId && tags.Custom["groupId"] == item.GroupId && tags.Custom["uid"] == item.Uid
+	}
+	return tags.Title == item.GroupId && tags.Artist == item.Uid && tags.Album == item.ItemId
+}
+
+// hashFile 旧版本在输出文件旁写入的哈希文件，MP4为 名称.hash，其它格式在文件名后追加.hash。
+// 现在哈希记录在索引中，只读取旧文件用于重复检测
+func hashFile(file string) string {
+	if filepath.Ext(file) == conver.Mp4Suffix {
+		return strings.TrimSuffix(file, conver.Mp4Suffix) + ".hash"
+	}
+	return file + ".hash"
+}
+
+// readTags 按扩展名读取MP4或MKV文件的元数据
+func readTags(file string) (mp4.Tags, error) {
+	if filepath.Ext(file) == conver.MkvSuffix {
+		return mkv.ReadTags(file)
+	}
+	return mp4.ReadTags(file)
+}
+
+// isIdenticalFileExists 检查目录中是否存在与输入音频和视频文件内容相同、格式相同的文件，
+// inputHash为输入文件的组合哈希，为空时比较文件大小
+func (p *Pipeline) isIdenticalFileExists(dirPath string, item *Item, inputHash string) (bool, string) {
+	// 读取目录中的所有文件
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		logrus.Errorf("读取目录失败: %v", err)
+		return false, ""
+	}
+
+	if inputHash == "" {
+		// 如果无法计算哈希，使用文件大小进行比较
+		videoInfo, err := os.Stat(item.Video)
+		if err != nil {
+			logrus.Errorf("获取视频文件信息失败: %v", err)
+			return false, ""
+		}
+
+		audioInfo, err := os.Stat(item.Audio)
+		if err != nil {
+			logrus.Errorf("获取音频文件信息失败: %v", err)
+			return false, ""
+		}
+
+		expectedSize := videoInfo.Size() + audioInfo.Size()
+
+		for _, file := range files {
+			// 跳过目录和正在写入的临时文件
+			if file.IsDir() || internal.IsTemp(file.Name()) {
+				continue
+			}
+
+			if filepath.Ext(file.Name()) != p.Ext() {
+				continue
+			}
+
+			filePath := filepath.Join(dirPath, file.Name())
+			fileInfo, err := os.Stat(filePath)
+			if err != nil {
+				logrus.Errorf("获取文件信息失败: %v", err)
+				continue
+			}
+
+			if abs(fileInfo.Size()-expectedSize) > 1024*1024 {
+				continue
+			}
+
+			logrus.Info("发现相似大小的文件: ", filePath, " 大小:", fileInfo.Size(), " 预期:", expectedSize)
+			return true, filePath
+		}
+
+		return false, ""
+	}
+
+	// 检查索引中记录的哈希
+	if existing, ok := p.index.Identical(dirPath, p.Ext(), inputHash); ok {
+		logrus.Info("发现相同内容的文件: ", existing)
+		return true, existing
+	}
+
+	// 检查每个已合成的文件
+	for _, file := range files {
+		// 跳过目录和正在写入的临时文件
+		if file.IsDir() || internal.IsTemp(file.Name()) {
+			continue
+		}
+
+		if filepath.Ext(file.Name()) != p.Ext() {
+			continue
+		}
+
+		filePath := filepath.Join(dirPath, file.Name())
+
+		// 检查旧版本的.hash文件
+		hashFilePath := hashFile(filePath)
+		if utils.IsExist(hashFilePath) {
+			hashContent, err := os.ReadFile(hashFilePath)
+			if err == nil && string(hashContent) == inputHash {
+				logrus.Info("发现相同内容的文件: ", filePath)
+				return true, filePath
+			}
+		}
+
+		// 检查文件的元数据
+		tags, err := readTags(filePath)
+		if err == nil && matchTags(tags, item) {
+			// 如果提供了part，还需要检查文件名中是否包含part
+			if part := item.Name(); part != "" {
+				if strings.Contains(file.Name(), part) {
+					logrus.Info("发现相同元数据和part的文件: ", filePath)
+					return true, filePath
+				}
+			} else {
+				logrus.Info("发现相同元数据的文件: ", filePath)
+				return true, filePath
+			}
+		}
+	}
+
+	return false, ""
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"m4s-converter/mp4"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FFmpeg 使用ffmpeg进行音视频合成，只复制流不转码
+type FFmpeg struct {
+	Path    string
+	Overlay bool // 覆盖同名文件
+}
+
+func (m *FFmpeg) Name() string {
+	return BackendFFmpeg
+}
+
+func (m *FFmpeg) overlay() string {
+	if m.Overlay {
+		return "-y"
+	}
+	return "-n"
+}
+
+// ffprobe 返回与ffmpeg同目录的ffprobe，找不到时在PATH中查找
+func (m *FFmpeg) ffprobe() string {
+	name := "ffprobe" + strings.TrimPrefix(filepath.Base(m.Path), "ffmpeg")
+	p := filepath.Join(filepath.Dir(m.Path), name)
+	if _, err := exec.LookPath(p); err == nil {
+		return p
+	}
+	if p, err := exec.LookPath("ffprobe"); err == nil {
+		return p
+	}
+	return ""
+}
+
+// Probe 使用ffprobe探测，找不到ffprobe时使用内置解析器
+func (m *FFmpeg) Probe(ctx context.Context, file string) ([]Stream, error) {
+	ffprobe := m.ffprobe()
+	if ffprobe == "" {
+		return probeFile(file)
+	}
+	out, err := exec.CommandContext(ctx, ffprobe, "-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,duration", "-of", "json", file).Output()
+	if err != nil {
+		return nil, err
+	}
+	var ret struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Duration  string `json:"duration"`
+		} `json:"streams"`
+	}
+	if err = json.Unmarshal(out, &ret); err != nil {
+		return nil, err
+	}
+	var streams []Stream
+	for _, v := range ret.Streams {
+		d, _ := strconv.ParseFloat(v.Duration, 64)
+		streams = append(streams, Stream{Type: v.CodecType, Codec: v.CodecName, Duration: time.Duration(d * float64(time.Second))})
+	}
+	return streams, nil
+}
+
+func (m *FFmpeg) Mux(ctx context.Context, job *Job) error {
+	// moov写在文件末尾，Tag可以原地修改元数据
+	args := []string{"-hide_banner", "-nostdin", m.overlay(),
+		"-i", job.Video, "-i", job.Audio}
+	// MKV直接复制ass字幕，MP4使用合并后的srt转换为mov_text
+	subtitle := job.Subtitle
+	if subtitle != "" && !job.MKV() {
+		srt, remove, err := danmakuSrt(job)
+		if err != nil {
+			return err
+		}
+		defer remove()
+		subtitle = srt
+	}
+	if subtitle != "" {
+		args = append(args, "-i", subtitle)
+	}
+	args = append(args, "-map", "0:v:0", "-map", "1:a:0")
+	if subtitle != "" {
+		args = append(args, "-map", "2:s:0",
+			"-metadata:s:s:0", "title="+danmakuName,
+			"-metadata:s:s:0", "handler_name="+danmakuName,
+			"-metadata:s:s:0", "language="+danmakuLanguage,
+			"-disposition:s:0", "0")
+	}
+	// FLAC封装到MP4在部分ffmpeg版本中仍标记为实验特性
+	args = append(args, "-c", "copy", "-map_metadata", "-1", "-strict", "experimental")
+	if subtitle != "" && !job.MKV() {
+		args = append(args, "-c:s", "mov_text")
+	}
+	if job.MKV() {
+		args = append(args, metadataArgs(job.Tags)...)
+	}
+	args = append(args, job.Output)
+	cmd := exec.CommandContext(ctx, m.Path, args...)
+
+	stdout := &lineWriter{}
+	if job.Progress != nil && job.Duration > 0 {
+		stdout.fn = func(line string) {
+			if t, ok := ffmpegTime(line); ok {
+				job.progress(min(float64(t)/float64(job.Duration)*100, 99))
+			}
+		}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("合成视频文件失败:%s\n%s", job.Output, stdout.String())
+		return err
+	}
+	return nil
+}
+
+// ffmpegTimeRe ffmpeg统计行中已写入的时长，如 "frame=  240 fps=0.0 ... time=00:00:08.00 bitrate=..."
+var ffmpegTimeRe = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// ffmpegTime 解析ffmpeg统计行中已写入的时长
+func ffmpegTime(line string) (time.Duration, bool) {
+	m := ffmpegTimeRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	mi, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	return time.Duration(h)*time.Hour + time.Duration(mi)*time.Minute + time.Duration(sec*float64(time.Second)), true
+}
+
+// metadataArgs 将元数据转换为ffmpeg的-metadata参数，用于输出MKV
+func metadataArgs(tags mp4.Tags) []string {
+	var args []string
+	add := func(k, v string) {
+		if v != "" {
+			args = append(args, "-metadata", k+"="+v)
+		}
+	}
+	add("title", tags.Title)
+	add("artist", tags.Artist)
+	add("album", tags.Album)
+	add("comment", tags.Comment)
+	add("date", tags.Date)
+	add("copyright", tags.Copyright)
+	keys := make([]string, 0, len(tags.Custom))
+	for k := range tags.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, tags.Custom[k])
+	}
+	return args
+}
+
+// Tag 使用内置解析器原地修改元数据，避免ffmpeg再复制一遍文件
+func (m *FFmpeg) Tag(_ context.Context, file string, tags mp4.Tags) error {
+	return mp4.WriteTags(file, tags)
+}
+
+func (m *FFmpeg) Verify(ctx context.Context, file string) error {
+	return verifyStreams(m.Probe(ctx, file))
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"m4s-converter/internal"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IndexFile 输出目录下记录已合成条目的索引文件
+const IndexFile = ".m4s-index.json"
+
+// Index 已合成条目的索引，来源文件未变化的条目再次运行时直接跳过，不再计算哈希或读取输出文件。
+// 同时记录输入文件的组合哈希，代替原来每个输出文件旁的.hash文件
+type Index struct {
+	path    string
+	mu      sync.Mutex
+	changed bool
+	Entries map[string]*IndexEntry `json:"entries"`
+}
+
+// IndexEntry 单个输出文件的记录
+type IndexEntry struct {
+	Output  string    `json:"output"`         // 相对于输出目录的路径
+	Hash    string    `json:"hash,omitempty"` // 输入音视频文件的组合哈希，导出音频时为空
+	Video   fileStamp `json:"video"`
+	Audio   fileStamp `json:"audio"`
+	Updated time.Time `json:"updated"`
+}
+
+// fileStamp 来源文件的大小和修改时间
+type fileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// equal 文件存在且大小和修改时间都相同
+func (s fileStamp) equal(o fileStamp) bool {
+	return s.Size > 0 && s.Size == o.Size && s.ModTime.Equal(o.ModTime)
+}
+
+func stamp(file string) fileStamp {
+	st, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{Size: st.Size(), ModTime: st.ModTime()}
+}
+
+// LoadIndex 读取输出目录下的索引，文件不存在或损坏时返回空索引
+func LoadIndex(outputDir string) *Index {
+	idx := &Index{path: filepath.Join(outputDir, IndexFile), Entries: map[string]*IndexEntry{}}
+	b, err := os.ReadFile(idx.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warn("读取索引文件失败: ", err)
+		}
+		return idx
+	}
+	if err = json.Unmarshal(b, idx); err != nil {
+		logrus.Warn("索引文件已损坏，将重新生成: ", err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string]*IndexEntry{}
+	}
+	return idx
+}
+
+// Save 有变化时写回索引文件
+func (idx *Index) Save() error {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.changed {
+		return nil
+	}
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(idx.path), os.ModePerm); err != nil {
+		return err
+	}
+	if err = internal.WriteFile(idx.path, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	}); err != nil {
+		return err
+	}
+	idx.changed = false
+	return nil
+}
+
+// indexKey 条目在索引中的键，优先使用cid，kind区分合成的视频格式和导出的音频
+func indexKey(item *Item, kind string) string {
+	switch {
+	case item.Cid != "":
+		return "cid:" + item.Cid + "|" + kind
+	case item.Bvid != "":
+		return "bvid:" + item.Bvid + "|" + item.ItemId + "|" + kind
+	}
+	return "dir:" + filepath.ToSlash(item.Dir) + "|" + kind
+}
+
+// Unchanged 条目的来源文件与上次合成时相同，且输出文件仍然存在时返回输出文件路径。
+// base为按当前的输出目录和命名设置计算的输出路径，不含扩展名，与记录的路径不同时需要重新合成
+func (idx *Index) Unchanged(item *Item, kind, base string) (string, bool) {
+	if idx == nil {
+		return "", false
+	}
+	idx.mu.Lock()
+	e := idx.Entries[indexKey(item, kind)]
+	idx.mu.Unlock()
+	if e == nil || !e.Video.equal(stamp(item.Video)) || !e.Audio.equal(stamp(item.Audio)) {
+		return "", false
+	}
+	output := filepath.Join(filepath.Dir(idx.path), filepath.FromSlash(e.Output))
+	if strings.TrimSuffix(output, filepath.Ext(output)) != filepath.Clean(base) || Size(output) == 0 {
+		return "", false
+	}
+	return output, true
+}
+
+// Identical 返回与hash相同、位于dir中且扩展名相同的已合成文件
+func (idx *Index) Identical(dir, ext, hash string) (string, bool) {
+	if idx == nil || hash == "" {
+		return "", false
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, e := range idx.Entries {
+		if e.Hash != hash {
+			continue
+		}
+		output := filepath.Join(filepath.Dir(idx.path), filepath.FromSlash(e.Output))
+		if filepath.Dir(output) == dir && filepath.Ext(output) == ext && Size(output) > 0 {
+			return output, true
+		}
+	}
+	return "", false
+}
+
+// Put 记录合成或导出成功的条目
+func (idx *Index) Put(item *Item, kind, output, hash string) {
+	if idx == nil {
+		return
+	}
+	rel, err := filepath.Rel(filepath.Dir(idx.path), output)
+	if err != nil {
+		return
+	}
+	e := &IndexEntry{
+		Output:  filepath.ToSlash(rel),
+		Hash:    hash,
+		Video:   stamp(item.Video),
+		Audio:   stamp(item.Audio),
+		Updated: time.Now(),
+	}
+	idx.mu.Lock()
+	idx.Entries[indexKey(item, kind)] = e
+	idx.changed = true
+	idx.mu.Unlock()
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	item := &Item{Cid: "100", Video: write("cache/video.mp4"), Audio: write("cache/audio.mp3")}
+	outputDir := filepath.Join(dir, "output")
+	output := write("output/UP主/标题.mp4")
+
+	idx := LoadIndex(outputDir)
+	idx.Put(item, ".mp4", output, "hash")
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	idx = LoadIndex(outputDir)
+
+	base := filepath.Join(outputDir, "UP主", "标题")
+	if got, ok := idx.Unchanged(item, ".mp4", base); !ok || got != output {
+		t.Fatalf("未变化的条目应跳过: %s %v", got, ok)
+	}
+	// 修改命名模板后输出路径不同
+	if _, ok := idx.Unchanged(item, ".mp4", filepath.Join(outputDir, "合集", "标题")); ok {
+		t.Fatal("输出路径变化时应重新合成")
+	}
+	// 修改输出格式
+	if _, ok := idx.Unchanged(item, ".mkv", base); ok {
+		t.Fatal("输出格式变化时应重新合成")
+	}
+	// 来源文件变化
+	write("cache/audio.mp3-changed")
+	if err := os.Rename(filepath.Join(dir, "cache/audio.mp3-changed"), item.Audio); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Unchanged(item, ".mp4", base); ok {
+		t.Fatal("来源文件变化时应重新合成")
+	}
+	// 输出文件已删除
+	idx.Put(item, ".mp4", output, "hash")
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Unchanged(item, ".mp4", base); ok {
+		t.Fatal("输出文件不存在时应重新合成")
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"m4s-converter/mp4"
+	"path/filepath"
+	"time"
+)
+
+// Item 单个缓存视频条目，合成过程中的状态都保存在条目上，不再写入共享的配置
+type Item struct {
+	Dir        string // 缓存目录
+	InfoFile   string // videoInfo.json、.videoInfo 或 entry.json 的路径
+	Video      string // 已修复的视频文件
+	Audio      string // 已修复的音频文件
+	AssPath    string // 弹幕ass文件，未生成时为空
+	Cover      string // 封面图片的本地路径或URL
+	GroupTitle string
+	Title      string
+	Part       string
+	Uname      string
+	Status     string
+	ItemId     string
+	GroupId    string
+	Uid        string
+	Cid        string // 用于下载弹幕和索引，PC客户端为m4s所在的目录名
+	Bvid       string
+	Uploader   string        // UP主名称，未知时为空
+	Page       int           // 分P或剧集的序号，未知时为0
+	PubDate    time.Time     // 发布时间，未知时为零值
+	Duration   time.Duration // 缓存信息中的视频时长，用于校验合成的文件，未知时为0
+
+	quality func() string // 由 Scanner.Scan 设置，读取一次后保存结果
+}
+
+// Quality 视频的清晰度，如 1080P、4K，读取视频文件失败时为空
+func (it *Item) Quality() string {
+	if it.quality == nil {
+		return ""
+	}
+	return it.quality()
+}
+
+// Completed 缓存是否已完成
+func (it *Item) Completed() bool {
+	return it.Status == "completed" || it.Status == "视频已缓存完成" || it.Status == ""
+}
+
+// GroupPath 输出目录下的分组目录名
+func (it *Item) GroupPath() string {
+	return it.GroupTitle + "-" + it.Uname
+}
+
+// Name 输出文件名（不含扩展名），分P名称为空时使用标题
+func (it *Item) Name() string {
+	if it.Part != "" {
+		return it.Part
+	}
+	return it.Title
+}
+
+// OutputFile 条目在输出目录下的完整路径，ext为 .mp4、.mkv 等扩展名
+func (it *Item) OutputFile(outputDir, ext string) string {
+	return filepath.Join(outputDir, it.GroupPath(), it.Name()+ext)
+}
+
+// Tags 生成条目写入输出文件的元数据，title、artist、album与MP4Box合成时写入的一致
+func (it *Item) Tags() mp4.Tags {
+	return mp4.Tags{
+		Title:     it.GroupId,
+		Artist:    it.Uid,
+		Album:     it.ItemId,
+		Copyright: it.ItemId,
+		Custom:    map[string]string{"itemId": it.ItemId, "groupId": it.GroupId, "uid": it.Uid},
+	}
+}
+
+// AudioTags 导出音频时写入的元数据，标题、UP主和合集名称便于播放器识别
+func (it *Item) AudioTags() mp4.Tags {
+	return mp4.Tags{
+		Title:  it.Name(),
+		Artist: it.Uname,
+		Album:  it.GroupTitle,
+		Custom: map[string]string{"itemId": it.ItemId, "groupId": it.GroupId, "uid": it.Uid},
+	}
+}
+
+// Status 条目的处理结果
+type Status string
+
+const (
+	StatusConverted Status = "converted"
+	StatusSkipped   Status = "skipped"
+	StatusFailed    Status = "failed"
+	StatusPlanned   Status = "planned" // 演练模式下将会合成或导出
+)
+
+// ItemResult 单个条目的处理结果
+type ItemResult struct {
+	Item    *Item
+	Output  string // 输出文件路径
+	Status  Status
+	Reason  string // 跳过或失败的原因
+	Err     error
+	Elapsed time.Duration // 处理耗时
+	Verify  *Verification // 合成后的校验结果，未合成或无法校验时为空
+}
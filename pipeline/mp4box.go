@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"m4s-converter/mp4"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MP4Box 使用GPAC的MP4Box进行音视频合成
+type MP4Box struct {
+	Path    string
+	Overlay bool // 覆盖同名文件
+}
+
+func (m *MP4Box) Name() string {
+	return BackendMP4Box
+}
+
+// Probe 使用内置解析器探测，不再解析 MP4Box -info 的文本输出
+func (m *MP4Box) Probe(_ context.Context, file string) ([]Stream, error) {
+	return probeMP4(file)
+}
+
+func (m *MP4Box) Mux(ctx context.Context, job *Job) error {
+	if job.MKV() {
+		return errors.New("MP4Box不支持输出MKV")
+	}
+	// 构建MP4Box命令行参数
+	var args []string
+	// 添加覆盖参数
+	if m.Overlay {
+		args = append(args, "-force")
+	}
+	args = append(args,
+		// "-quiet", // 仅打印异常日志
+		"-add", job.Video+"#video",
+		"-add", job.Audio+"#audio")
+	if job.Subtitle != "" {
+		srt, remove, err := danmakuSrt(job)
+		if err != nil {
+			return err
+		}
+		defer remove()
+		args = append(args, "-add", srt+":lang="+danmakuLanguage+":name="+danmakuName+":disable")
+	}
+	args = append(args, "-new", job.Output)
+	var parse func(string)
+	if job.Progress != nil {
+		// 导入每个输入文件和写入输出文件各为一个阶段
+		phases := 1
+		for _, a := range args {
+			if a == "-add" {
+				phases++
+			}
+		}
+		parse = mp4boxProgress(phases, job.progress)
+	}
+	return m.run(ctx, job.Output, parse, args...)
+}
+
+// Tag 原地修改元数据
+func (m *MP4Box) Tag(ctx context.Context, file string, tags mp4.Tags) error {
+	// 添加字符集参数，指定使用UTF-8编码
+	args := []string{"-charset", "utf8"}
+	args = append(args, "-tags", fmt.Sprintf("title=%s:artist=%s:album=%s", tags.Title, tags.Artist, tags.Album))
+	if tags.Copyright != "" {
+		args = append(args, "-cprt", tags.Copyright)
+	}
+	args = append(args, file)
+	return m.run(ctx, file, nil, args...)
+}
+
+func (m *MP4Box) Verify(_ context.Context, file string) error {
+	return verifyStreams(probeMP4(file))
+}
+
+// run 执行MP4Box，parse不为空时逐行解析输出中的进度
+func (m *MP4Box) run(ctx context.Context, outputFile string, parse func(string), args ...string) error {
+	cmd := exec.CommandContext(ctx, m.Path, args...)
+
+	stdout := &lineWriter{fn: parse}
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+
+	// 等待命令执行完成
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("合成视频文件失败:%s\n%s", outputFile, stdout.String())
+		return err
+	}
+	return nil
+}
+
+// mp4boxProgressRe MP4Box的进度行，如 "ISO File Writing: |=====          | (32/100)"
+var mp4boxProgressRe = regexp.MustCompile(`^\s*(.*?): \|.*\| \((\d+)/100\)`)
+
+// mp4boxProgress 将MP4Box各阶段的进度换算为整体进度，标题变化或进度回退时视为进入下一阶段
+func mp4boxProgress(phases int, fn func(float64)) func(string) {
+	title, phase, last := "", -1, 0
+	return func(line string) {
+		m := mp4boxProgressRe.FindStringSubmatch(line)
+		if m == nil {
+			return
+		}
+		cur, _ := strconv.Atoi(m[2])
+		if m[1] != title || cur < last {
+			title, phase = m[1], min(phase+1, phases-1)
+		}
+		last = cur
+		fn((float64(phase)*100 + float64(cur)) / float64(phases))
+	}
+}
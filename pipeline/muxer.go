@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"m4s-converter/conver"
+	"m4s-converter/internal"
+	"m4s-converter/mkv"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 流类型
+const (
+	StreamVideo    = "video"
+	StreamAudio    = "audio"
+	StreamSubtitle = "subtitle"
+)
+
+// 输出格式
+const (
+	FormatMP4 = "mp4"
+	FormatMKV = "mkv"
+)
+
+// 合成后端名称
+const (
+	BackendAuto   = "auto"
+	BackendNative = "native"
+	BackendMP4Box = "mp4box"
+	BackendFFmpeg = "ffmpeg"
+)
+
+// Stream 探测到的音视频流
+type Stream struct {
+	Type     string // video、audio、subtitle
+	Codec    string
+	Duration time.Duration
+}
+
+// Job 一次合成的输入和输出
+type Job struct {
+	Video    string
+	Audio    string
+	Subtitle string // 弹幕ass文件，为空时不写入。MKV写为ASS字幕轨道，MP4写为文本轨道
+	Output   string
+	Tags     mp4.Tags // 输出MKV时在合成时写入的元数据
+	// Progress 合成进度，0-100，为空时不报告
+	Progress func(percent float64)
+	Duration time.Duration // 视频的时长，由 Composition 探测得到，用于从ffmpeg的输出中计算进度
+
+	Expected     time.Duration // 缓存信息中的视频时长，未知时为0，用于校验输出文件
+	OnVerifyFail string        // 校验未通过时的处理方式，见 OnVerifyFailKeep 等，为空时保留
+	Quarantine   string        // 校验未通过且 OnVerifyFail 为 OnVerifyFailQuarantine 时文件移动到的路径
+	Verify       *Verification // 输出文件的校验结果，由 Composition 设置，无法校验时为nil
+}
+
+// progress 报告合成进度
+func (j *Job) progress(percent float64) {
+	if j.Progress != nil {
+		j.Progress(percent)
+	}
+}
+
+// MKV 是否输出为Matroska文件
+func (j *Job) MKV() bool {
+	return filepath.Ext(j.Output) == conver.MkvSuffix
+}
+
+// Muxer 合成后端，Composition 依次调用探测、合成、写入元数据和校验
+type Muxer interface {
+	// Name 后端名称
+	Name() string
+	// Probe 探测文件中的音视频流
+	Probe(ctx context.Context, file string) ([]Stream, error)
+	// Mux 将音视频和弹幕合成为输出文件，MP4不写入元数据，MKV同时写入元数据
+	Mux(ctx context.Context, job *Job) error
+	// Tag 写入MP4文件的元数据
+	Tag(ctx context.Context, file string, tags mp4.Tags) error
+	// Verify 校验输出文件
+	Verify(ctx context.Context, file string) error
+}
+
+// Composition 合成音视频并写入元数据，输出文件的扩展名决定格式。先合成到同目录的临时文件，
+// 写入元数据和校验通过后再重命名为输出文件；失败或ctx取消时停止外部合成程序并删除临时文件。
+// 校验未通过时按 OnVerifyFail 保留、删除或移到隔离目录，删除时返回 *Verification 错误
+func Composition(ctx context.Context, m Muxer, job *Job) error {
+	var srcVideo, srcAudio time.Duration
+	for _, v := range []struct {
+		file, typ string
+		duration  *time.Duration
+	}{{job.Video, StreamVideo, &srcVideo}, {job.Audio, StreamAudio, &srcAudio}} {
+		streams, err := m.Probe(ctx, v.file)
+		if err != nil {
+			return fmt.Errorf("探测文件失败: %s: %v", v.file, err)
+		}
+		if !hasStream(streams, v.typ) {
+			return fmt.Errorf("文件中找不到%s流: %s", v.typ, v.file)
+		}
+		for _, s := range streams {
+			if s.Type == StreamVideo {
+				job.Duration = max(job.Duration, s.Duration)
+			}
+			if s.Type == v.typ {
+				*v.duration = max(*v.duration, s.Duration)
+			}
+		}
+	}
+
+	tmp := *job
+	tmp.Output = internal.TempName(job.Output)
+	defer internal.Writing(tmp.Output)()
+	_ = os.Remove(tmp.Output) // 上次异常退出时留下的临时文件
+	err := m.Mux(ctx, &tmp)
+	if err == nil && !job.MKV() {
+		err = m.Tag(ctx, tmp.Output, job.Tags)
+	}
+	if err == nil {
+		err = m.Verify(ctx, tmp.Output)
+	}
+	dst := job.Output
+	if err == nil {
+		// 校验轨道数、时长和音视频同步，通过后才出现在输出路径
+		v, e := verifyOutput(tmp.Output, srcVideo, srcAudio, job.Expected)
+		if e != nil {
+			logrus.Warnf("%s 无法校验: %v", filepath.Base(job.Output), e)
+		}
+		job.Verify = v
+		if !v.OK() {
+			switch job.OnVerifyFail {
+			case OnVerifyFailDelete:
+				err = v
+			case OnVerifyFailQuarantine:
+				dst = job.Quarantine
+				_ = os.Remove(dst)
+				err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+			}
+		}
+	}
+	if err == nil {
+		err = syncFile(tmp.Output)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Output, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Output)
+		return err
+	}
+	return nil
+}
+
+// syncFile 将外部程序写入的文件同步到磁盘
+func syncFile(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func hasStream(streams []Stream, typ string) bool {
+	for _, s := range streams {
+		if s.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyStreams 输出文件需要同时包含视频流和音频流
+func verifyStreams(streams []Stream, err error) error {
+	if err != nil {
+		return err
+	}
+	if !hasStream(streams, StreamVideo) || !hasStream(streams, StreamAudio) {
+		return errors.New("合成的文件缺少视频流或音频流")
+	}
+	return nil
+}
+
+// probeMP4 使用内置解析器探测MP4文件
+func probeMP4(file string) ([]Stream, error) {
+	m, err := mp4.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	var streams []Stream
+	for _, t := range m.Tracks {
+		s := Stream{Codec: t.Codec, Duration: t.Time()}
+		switch t.Handler {
+		case mp4.Video:
+			s.Type = StreamVideo
+		case mp4.Audio:
+			s.Type = StreamAudio
+		case mp4.Subtitle, mp4.Text:
+			s.Type = StreamSubtitle
+		default:
+			s.Type = t.Handler
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+// probeFile 使用内置解析器探测MP4或MKV文件
+func probeFile(file string) ([]Stream, error) {
+	if filepath.Ext(file) != conver.MkvSuffix {
+		return probeMP4(file)
+	}
+	info, err := mkv.Probe(file)
+	if err != nil {
+		return nil, err
+	}
+	var streams []Stream
+	for _, t := range info.Tracks {
+		s := Stream{Codec: t.CodecID, Duration: info.Duration}
+		switch t.Type {
+		case mkv.TrackVideo:
+			s.Type = StreamVideo
+		case mkv.TrackAudio:
+			s.Type = StreamAudio
+		case mkv.TrackSubtitle:
+			s.Type = StreamSubtitle
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+// MuxerOptions 合成后端的选择参数
+type MuxerOptions struct {
+	Backend    string // auto、native、mp4box、ffmpeg
+	GPACPath   string // MP4Box路径，为空时在PATH中查找
+	FFmpegPath string // ffmpeg路径，为空时在PATH中查找
+	Format     string // 输出格式，MP4Box不支持输出MKV
+	Overlay    bool   // 覆盖同名文件
+}
+
+// NewMuxer 创建合成后端，auto依次查找PATH中的ffmpeg和MP4Box，都找不到时使用内置封装器
+func NewMuxer(o MuxerOptions) (Muxer, error) {
+	switch o.Backend {
+	case BackendNative, "":
+		return &Native{}, nil
+	case BackendMP4Box:
+		if o.Format == FormatMKV {
+			return nil, errors.New("MP4Box不支持输出MKV,请使用native或ffmpeg合成")
+		}
+		if o.GPACPath == "" {
+			if o.GPACPath = internal.GetMP4Box(); o.GPACPath == "" {
+				return nil, errors.New("找不到MP4Box命令,安装GPAC后重试")
+			}
+		}
+		return &MP4Box{Path: o.GPACPath, Overlay: o.Overlay}, nil
+	case BackendFFmpeg:
+		if o.FFmpegPath == "" {
+			if o.FFmpegPath = internal.GetFFmpeg(); o.FFmpegPath == "" {
+				return nil, errors.New("找不到ffmpeg命令,安装FFmpeg后重试")
+			}
+		}
+		return &FFmpeg{Path: o.FFmpegPath, Overlay: o.Overlay}, nil
+	case BackendAuto:
+		for _, b := range []string{BackendFFmpeg, BackendMP4Box} {
+			o.Backend = b
+			if m, err := NewMuxer(o); err == nil {
+				logrus.Info("自动选择合成后端: ", b)
+				return m, nil
+			}
+		}
+		return &Native{}, nil
+	}
+	return nil, fmt.Errorf("不支持的合成后端: %s", o.Backend)
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NameData 输出路径模板中可用的字段，字段中的路径分隔符等字符已替换
+type NameData struct {
+	GroupTitle string    // 合集、番剧或视频的名称
+	Title      string    // 标题
+	Part       string    // 分P或剧集名称，可能为空
+	Name       string    // 默认的文件名，分P名称为空时为标题
+	Uname      string    // 默认分组目录中的UP主，未知时可能沿用标题
+	Uploader   string    // UP主名称，未知时为空
+	UID        string    // UP主的uid
+	PageIndex  int       // 分P或剧集的序号，未知时为0
+	BVID       string    // BV号
+	CID        string    // 分P的cid
+	PubDate    time.Time // 发布时间，未知时为零值
+
+	quality func() string
+}
+
+// Quality 视频的清晰度，如 1080P、4K，读取视频文件失败时为空。只在模板中用到时读取
+func (d *NameData) Quality() string {
+	if d.quality == nil {
+		return ""
+	}
+	return d.quality()
+}
+
+// nameFuncs 模板中可用的辅助函数
+var nameFuncs = template.FuncMap{
+	// pad 用0补齐到指定宽度，如 {{pad 3 .PageIndex}} 为 001
+	"pad": func(width int, v any) string {
+		s := fmt.Sprint(v)
+		if n := width - utf8.RuneCountInString(s); n > 0 {
+			return strings.Repeat("0", n) + s
+		}
+		return s
+	},
+	// trunc 截取前n个字符，如 {{trunc 20 .Title}}
+	"trunc": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:max(n, 0)])
+		}
+		return s
+	},
+	// date 按Go的时间格式格式化日期，时间未知时为空，如 {{date "2006-01-02" .PubDate}}
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(layout)
+	},
+}
+
+// NameTemplate 输出路径模板，使用Go的text/template语法，结果中的/分隔目录，最后一段为不含扩展名的文件名
+type NameTemplate struct {
+	t *template.Template
+}
+
+// ParseNameTemplate 解析输出路径模板，并用示例数据检查字段名是否正确
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	t, err := template.New("name").Funcs(nameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("输出路径模板解析失败: %v", err)
+	}
+	n := &NameTemplate{t: t}
+	sample := &NameData{GroupTitle: "合集", Title: "标题", Part: "分P", Name: "分P", Uname: "UP主", Uploader: "UP主",
+		UID: "1", PageIndex: 1, BVID: "BV1xx411c7mD", CID: "1", PubDate: time.Now()}
+	if _, err = n.Path(sample); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Path 生成相对于输出目录的路径，不含扩展名。每一段都会替换文件名中不能使用的字符，空的目录会被忽略
+func (n *NameTemplate) Path(d *NameData) (string, error) {
+	var b strings.Builder
+	if err := n.t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("输出路径模板执行失败: %v", err)
+	}
+	var parts []string
+	for _, s := range strings.FieldsFunc(b.String(), func(r rune) bool { return r == '/' || r == '\\' }) {
+		s = Filter(s, nil)
+		if s == "" || s == "." || s == ".." {
+			continue
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return "", errors.New("输出路径模板生成的文件名为空")
+	}
+	return filepath.Join(parts...), nil
+}
+
+// OutputFile 条目在输出目录下的完整路径，ext为 .mp4、.mkv 等扩展名。
+// 未指定 NameTemplate 或模板执行失败时为 分组目录/名称
+func (p *Pipeline) OutputFile(item *Item, outputDir, ext string) string {
+	if p.NameTemplate == nil {
+		return item.OutputFile(outputDir, ext)
+	}
+	d := &NameData{
+		GroupTitle: item.GroupTitle,
+		Title:      item.Title,
+		Part:       item.Part,
+		Name:       item.Name(),
+		Uname:      item.Uname,
+		Uploader:   item.Uploader,
+		UID:        item.Uid,
+		PageIndex:  item.Page,
+		BVID:       item.Bvid,
+		CID:        item.Cid,
+		PubDate:    item.PubDate,
+		quality:    item.Quality,
+	}
+	name, err := p.NameTemplate.Path(d)
+	if err != nil {
+		logrus.Warnf("%s，使用默认的输出路径: %s", err, item.Dir)
+		return item.OutputFile(outputDir, ext)
+	}
+	return filepath.Join(outputDir, name+ext)
+}
+
+// qualityLabel 按视频高度生成清晰度名称
+func qualityLabel(height int) string {
+	switch {
+	case height <= 0:
+		return ""
+	case height >= 4320:
+		return "8K"
+	case height >= 2160:
+		return "4K"
+	}
+	return strconv.Itoa(height) + "P"
+}
@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutputFile(t *testing.T) {
+	probes := 0
+	item := &Item{GroupTitle: "合集", Title: "标题", Part: "分P", Uname: "UP主", Uploader: "UP主", Page: 3,
+		Bvid: "BV1xx411c7mD", PubDate: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)}
+	item.quality = sync.OnceValue(func() string {
+		probes++
+		return qualityLabel(1080)
+	})
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", filepath.Join("out", "合集-UP主", "分P.mp4")},
+		{"{{.Uploader}}/{{pad 3 .PageIndex}}-{{.Name}}", filepath.Join("out", "UP主", "003-分P.mp4")},
+		{`{{date "2006" .PubDate}}/{{.Quality}}/{{trunc 1 .Title}}`, filepath.Join("out", "2024", "1080P", "标.mp4")},
+		{"{{.Quality}}/../{{.BVID}}", filepath.Join("out", "1080P", "BV1xx411c7mD.mp4")},
+	}
+	for _, tt := range tests {
+		p := &Pipeline{}
+		if tt.template != "" {
+			n, err := ParseNameTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.NameTemplate = n
+		}
+		for i := 0; i < 2; i++ {
+			if got := p.OutputFile(item, "out", ".mp4"); got != tt.want {
+				t.Fatalf("%q 生成 %s, 期望 %s", tt.template, got, tt.want)
+			}
+		}
+	}
+	if probes != 1 {
+		t.Fatalf("读取了%d次视频文件，每个条目应只读取一次", probes)
+	}
+}
+
+func TestQualityLabel(t *testing.T) {
+	for height, want := range map[int]string{0: "", 360: "360P", 1080: "1080P", 2160: "4K", 4320: "8K"} {
+		if got := qualityLabel(height); got != want {
+			t.Errorf("qualityLabel(%d) = %q, 期望 %q", height, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"m4s-converter/mkv"
+	"m4s-converter/mp4"
+)
+
+// Native 使用内置的MP4和MKV封装器合成，不依赖MP4Box等外部程序
+type Native struct{}
+
+func (m *Native) Name() string {
+	return BackendNative
+}
+
+func (m *Native) Probe(_ context.Context, file string) ([]Stream, error) {
+	return probeMP4(file)
+}
+
+func (m *Native) Mux(ctx context.Context, job *Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	video, err := mp4.Open(job.Video)
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+	audio, err := mp4.Open(job.Audio)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+
+	vt, at := video.Track(mp4.Video), audio.Track(mp4.Audio)
+	if vt == nil || at == nil {
+		return errors.New("找不到视频轨道或音频轨道")
+	}
+	tracks := []*mp4.Track{vt, at}
+	if !job.MKV() {
+		if job.Subtitle != "" {
+			cues, err := danmakuCues(job.Subtitle)
+			if err != nil {
+				return fmt.Errorf("读取弹幕文件失败: %s: %v", job.Subtitle, err)
+			}
+			tt := mp4.NewTextTrack(cues, danmakuName, danmakuLanguage, vt.Width, vt.Height)
+			tt.Disabled = true
+			tracks = append(tracks, tt)
+		}
+		return writeFile(ctx, job, func(w io.Writer) error {
+			return mp4.Write(w, tracks, mp4.Tags{})
+		})
+	}
+	var subs []*mkv.Subtitle
+	if job.Subtitle != "" {
+		s, err := danmakuSubtitle(job.Subtitle)
+		if err != nil {
+			return fmt.Errorf("读取弹幕文件失败: %s: %v", job.Subtitle, err)
+		}
+		subs = append(subs, s)
+	}
+	return writeFile(ctx, job, func(w io.Writer) error {
+		return mkv.Write(w, tracks, subs, job.Tags)
+	})
+}
+
+// writeFile 创建输出文件并写入，按写入的字节数与输入文件大小之比报告进度，ctx取消时中断写入
+func writeFile(ctx context.Context, job *Job, write func(io.Writer) error) error {
+	var progress func(n, total int64)
+	if job.Progress != nil {
+		progress = func(n, total int64) { job.progress(percentOf(n, total)) }
+	}
+	return createFile(ctx, job.Output, Size(job.Video)+Size(job.Audio), progress, write)
+}
+
+// Tag 在moov后预留的空间中原地写入元数据
+func (m *Native) Tag(_ context.Context, file string, tags mp4.Tags) error {
+	return mp4.WriteTags(file, tags)
+}
+
+func (m *Native) Verify(_ context.Context, file string) error {
+	return verifyStreams(probeFile(file))
+}
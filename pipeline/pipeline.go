@@ -0,0 +1,381 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	utils "github.com/mzky/utils/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Pipeline 串联 Scanner → Item → Muxer 的合成流程
+type Pipeline struct {
+	Scanner   *Scanner
+	Muxer     Muxer
+	OutputDir string // 输出目录，为空时使用缓存目录下的output
+	Format    string // 输出格式: mp4(默认)、mkv
+	Embed     bool   // MP4输出时将弹幕写入文本轨道，不再生成单独的ass文件。MKV总是写入
+	Audio     bool   // 合成视频的同时导出音频文件
+	AudioOnly bool   // 只导出音频文件，不合成视频
+	Summarize bool   // 将未合并的音视频文件放入汇总目录
+	DryRun    bool   // 演练模式，只判断每个条目的输出路径和是否跳过，不写入任何文件
+	Jobs      int    // 同时合成的条目数，小于1时为1
+	// OnVerifyFail 合成后校验未通过时对输出文件的处理: keep(默认)、delete、quarantine
+	OnVerifyFail string
+	// NameTemplate 输出路径模板，为空时输出到 分组目录/名称
+	NameTemplate *NameTemplate
+	// OnEvent 接收运行过程中的进度事件，见 Event。同一次运行中不会并发调用
+	OnEvent func(Event)
+
+	index *Index // 输出目录下已合成条目的索引
+}
+
+// Result 一次合成任务的结果
+type Result struct {
+	CachePath string
+	OutputDir string
+	Items     []ItemResult
+	Begin     time.Time
+	End       time.Time
+}
+
+// Filter 返回指定状态的条目结果
+func (r *Result) Filter(status Status) []ItemResult {
+	var results []ItemResult
+	for _, v := range r.Items {
+		if v.Status == status {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+// Output 输出目录，未指定时为缓存目录下的output
+func (p *Pipeline) Output() string {
+	if p.OutputDir != "" {
+		return p.OutputDir
+	}
+	return filepath.Join(p.Scanner.CachePath, "output")
+}
+
+// New 创建使用内置封装器合成的流程
+func New(cachePath string) *Pipeline {
+	return &Pipeline{
+		Scanner: &Scanner{CachePath: cachePath},
+		Muxer:   &Native{},
+	}
+}
+
+// Run 执行合成任务，ctx取消时处理完正在合成的条目后返回
+func (p *Pipeline) Run(ctx context.Context) (*Result, error) {
+	res := &Result{CachePath: p.Scanner.CachePath, OutputDir: p.Output(), Begin: time.Now()}
+	defer func() { res.End = time.Now() }()
+
+	p.index = LoadIndex(res.OutputDir)
+	defer func() {
+		if err := p.index.Save(); err != nil {
+			logrus.Warn("保存索引文件失败: ", err)
+		}
+	}()
+
+	events := newTracker(p.OnEvent)
+	p.Scanner.events = events
+	defer func() { p.Scanner.events = nil }()
+	items, err := p.Scanner.Scan(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	// 并发计算输入文件的哈希，用于跳过相同的视频。索引中未变化或输出文件已存在的条目无需计算
+	hashes := make([]string, len(items))
+	if !p.AudioOnly {
+		parallel(ctx, p.Jobs, len(items), func(i int) {
+			output := p.OutputFile(items[i], res.OutputDir, p.Ext())
+			if _, ok := p.index.Unchanged(items[i], p.Ext(), strings.TrimSuffix(output, p.Ext())); ok || utils.IsExist(output) {
+				return
+			}
+			if items[i].Completed() {
+				hashes[i] = p.Scanner.CombinedHash(ctx, items[i].Video, items[i].Audio)
+			}
+		})
+	}
+
+	// 按条目顺序判断是否跳过，保证结果与逐个合成时一致，再并发执行合成和导出
+	type task struct {
+		result  ItemResult
+		run     func(context.Context, func(float64)) ItemResult
+		elapsed time.Duration
+		done    bool
+	}
+	var tasks []*task
+	c := &claims{}
+	for i, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		if !p.AudioOnly {
+			begin := time.Now()
+			r, run := p.prepare(item, res.OutputDir, hashes[i], c)
+			tasks = append(tasks, &task{result: r, run: run, elapsed: time.Since(begin)})
+		}
+		if p.Audio || p.AudioOnly {
+			begin := time.Now()
+			r, run := p.prepareAudio(item, res.OutputDir, c)
+			tasks = append(tasks, &task{result: r, run: run, elapsed: time.Since(begin)})
+		}
+	}
+	sizes := make([]int64, len(tasks))
+	for i, t := range tasks {
+		if t.run != nil {
+			sizes[i] = Size(t.result.Item.Video) + Size(t.result.Item.Audio)
+		}
+	}
+	events.start(sizes)
+	parallel(ctx, p.Jobs, len(tasks), func(i int) {
+		t := tasks[i]
+		if t.run != nil {
+			begin := time.Now()
+			t.result = t.run(ctx, func(percent float64) { events.mux(i, t.result.Output, percent) })
+			t.elapsed += time.Since(begin)
+		}
+		t.done = true
+		r := t.result
+		r.Elapsed = t.elapsed
+		events.finish(i, r)
+	})
+	for _, t := range tasks {
+		if t.done {
+			t.result.Elapsed = t.elapsed
+			res.Items = append(res.Items, t.result)
+		}
+	}
+	if ctx.Err() != nil {
+		logrus.Info("正在退出程序...")
+		return res, ctx.Err()
+	}
+
+	// 处理未合并的MP3和视频文件
+	if p.Summarize && !p.DryRun {
+		for _, item := range items {
+			p.summarize(ctx, item, res.OutputDir)
+		}
+	}
+	return res, nil
+}
+
+// claims 本次运行中已分配的输出文件和输入哈希。条目并发合成时，
+// 后面的条目看不到前面条目尚未写入的文件，需要按顺序记录下来
+type claims struct {
+	outputs map[string]bool
+	hashes  map[string]string // 分组目录、扩展名和输入哈希 → 输出文件
+}
+
+// has 输出文件是否已被本次运行中前面的条目使用
+func (c *claims) has(file string) bool {
+	return c != nil && c.outputs[file]
+}
+
+// identical 本次运行中前面的条目是否会在同一目录合成内容相同的文件
+func (c *claims) identical(file, hash string) (string, bool) {
+	if c == nil || hash == "" {
+		return "", false
+	}
+	existing, ok := c.hashes[claimKey(file, hash)]
+	return existing, ok
+}
+
+// claim 记录将要写入的输出文件
+func (c *claims) claim(file, hash string) {
+	if c == nil {
+		return
+	}
+	if c.outputs == nil {
+		c.outputs, c.hashes = map[string]bool{}, map[string]string{}
+	}
+	c.outputs[file] = true
+	if hash != "" {
+		c.hashes[claimKey(file, hash)] = file
+	}
+}
+
+func claimKey(file, hash string) string {
+	return filepath.Dir(file) + "|" + filepath.Ext(file) + "|" + hash
+}
+
+// Convert 合成单个条目
+func (p *Pipeline) Convert(ctx context.Context, item *Item, outputDir string) ItemResult {
+	var hash string
+	if item.Completed() {
+		hash = p.Scanner.CombinedHash(ctx, item.Video, item.Audio)
+	}
+	r, run := p.prepare(item, outputDir, hash, nil)
+	if run == nil {
+		return r
+	}
+	return run(ctx, nil)
+}
+
+// prepare 判断条目是否需要合成，需要时返回执行合成的函数。hash为输入文件的组合哈希
+func (p *Pipeline) prepare(item *Item, outputDir, hash string, c *claims) (ItemResult, func(context.Context, func(float64)) ItemResult) {
+	outputFile := p.OutputFile(item, outputDir, p.Ext())
+	r := ItemResult{Item: item, Output: outputFile, Status: StatusSkipped}
+	if !item.Completed() {
+		logrus.Warn("未缓存完成,跳过合成", item.Dir, item.Title+"-"+item.Uname)
+		r.Reason = "未缓存完成"
+		return r, nil
+	}
+	if existing, ok := p.index.Unchanged(item, p.Ext(), strings.TrimSuffix(outputFile, p.Ext())); ok {
+		logrus.Warn("跳过未变化的已合并文件: ", existing)
+		r.Output, r.Reason = existing, "已合并"
+		c.claim(existing, "")
+		return r, nil
+	}
+	groupDir := filepath.Dir(outputFile)
+	if !utils.IsExist(groupDir) && !p.DryRun {
+		if err := os.MkdirAll(groupDir, os.ModePerm); err != nil {
+			r.Status, r.Err = StatusFailed, fmt.Errorf("无法创建目录：%s", groupDir)
+			return r, nil
+		}
+	}
+
+	// 检查是否已经存在已合并文件
+	if utils.IsExist(outputFile) || c.has(outputFile) {
+		// 提取已合并文件的元数据
+		if tags, getErr := readTags(outputFile); getErr == nil && matchTags(tags, item) {
+			logrus.Warn("跳过已合并文件: ", outputFile)
+		} else {
+			// 如果元数据提取失败或验证失败，仍然跳过同名文件
+			logrus.Warn("跳过已合并的视频: ", outputFile)
+		}
+		r.Reason = "已合并"
+		return r, nil
+	}
+
+	// 检查目录中是否存在与输入音频和视频文件内容相同的文件
+	if utils.IsExist(groupDir) {
+		if exists, existingFile := p.isIdenticalFileExists(groupDir, item, hash); exists {
+			logrus.Warn("跳过完全相同的视频: ", existingFile)
+			r.Reason = "存在完全相同的视频: " + existingFile
+			if !p.DryRun {
+				// 下次运行时来源文件未变化则直接跳过，无需再计算哈希
+				p.index.Put(item, p.Ext(), existingFile, hash)
+			}
+			return r, nil
+		}
+	}
+	if existingFile, exists := c.identical(outputFile, hash); exists {
+		logrus.Warn("跳过完全相同的视频: ", existingFile)
+		r.Reason = "存在完全相同的视频: " + existingFile
+		return r, nil
+	}
+	c.claim(outputFile, hash)
+	if p.DryRun {
+		r.Status = StatusPlanned
+		return r, nil
+	}
+
+	return r, func(ctx context.Context, progress func(float64)) ItemResult {
+		return p.compose(ctx, r, outputDir, hash, progress)
+	}
+}
+
+// compose 执行合成、校验输出文件并记录输入文件的哈希，progress不为空时报告合成进度
+func (p *Pipeline) compose(ctx context.Context, r ItemResult, outputDir, hash string, progress func(float64)) ItemResult {
+	item, outputFile := r.Item, r.Output
+	job := &Job{Video: item.Video, Audio: item.Audio, Output: outputFile, Tags: item.Tags(), Progress: progress,
+		Expected: item.Duration, OnVerifyFail: p.OnVerifyFail, Quarantine: quarantinePath(outputFile, outputDir)}
+	if p.Format == FormatMKV || p.Embed {
+		job.Subtitle = item.AssPath
+	} else if item.AssPath != "" {
+		// 弹幕不写入视频文件时，在旁边放一份ass文件
+		assFile := strings.TrimSuffix(outputFile, conver.Mp4Suffix) + conver.AssSuffix
+		_ = copyFile(ctx, item.AssPath, assFile)
+	}
+
+	// 执行合成，合成后校验输出文件的轨道数、时长和音视频同步
+	err := Composition(ctx, p.Muxer, job)
+	r.Verify = job.Verify
+	var unverified *Verification
+	switch {
+	case errors.As(err, &unverified):
+		logrus.Warnf("%s %v，已删除合成的文件", filepath.Base(outputFile), job.Verify)
+		r.Status, r.Err, r.Output = StatusFailed, err, ""
+		return r
+	case err != nil:
+		if ctx.Err() != nil {
+			logrus.Warn("已取消合成并删除未完成的文件: ", outputFile)
+		} else {
+			logrus.Errorf("%s 合成失败", filepath.Base(outputFile))
+		}
+		r.Status, r.Err = StatusFailed, err
+		return r
+	case !job.Verify.OK() && p.OnVerifyFail == OnVerifyFailQuarantine:
+		logrus.Warnf("%s %v", filepath.Base(outputFile), job.Verify)
+		logrus.Warn("已将校验未通过的文件移到: ", job.Quarantine)
+		r.Status, r.Err, r.Output = StatusFailed, job.Verify, job.Quarantine
+		return r
+	case !job.Verify.OK():
+		logrus.Warnf("%s %v", filepath.Base(outputFile), job.Verify)
+	}
+	logrus.Info("已合成视频文件:", outputFile)
+
+	// 记录到索引，用于后续的增量运行和重复检测
+	p.index.Put(item, p.Ext(), outputFile, hash)
+	r.Status = StatusConverted
+	return r
+}
+
+// Ext 合成的视频文件的扩展名
+func (p *Pipeline) Ext() string {
+	if p.Format == FormatMKV {
+		return conver.MkvSuffix
+	}
+	return conver.Mp4Suffix
+}
+
+// summarize 将条目未合并的音视频文件复制到汇总目录
+func (p *Pipeline) summarize(ctx context.Context, item *Item, outputDir string) {
+	// 添加空值检查，避免创建空目录名或尝试复制空文件路径
+	if item.GroupTitle == "" && item.Uname == "" {
+		logrus.Warn("项目信息为空，跳过处理未合并文件: ", item.Dir)
+		return
+	}
+
+	// 创建项目特定的未合并文件夹
+	summaryDir := filepath.Join(filepath.Dir(p.OutputFile(item, outputDir, p.Ext())), "未合并文件")
+	if !utils.IsExist(summaryDir) {
+		if err := os.MkdirAll(summaryDir, os.ModePerm); err != nil {
+			logrus.Error("创建未合并文件目录失败: ", err)
+			return
+		}
+	}
+	if item.Title == "" {
+		return
+	}
+
+	// 复制未合并的视频文件
+	videoDest := filepath.Join(summaryDir, item.Title+"_video"+filepath.Ext(item.Video))
+	if !utils.IsExist(videoDest) {
+		if err := copyFile(ctx, item.Video, videoDest); err == nil {
+			logrus.Info("已将未合并的视频文件放入汇总目录: ", videoDest)
+		}
+	} else {
+		logrus.Warn("未合并的视频文件已存在，跳过复制: ", videoDest)
+	}
+
+	// 复制未合并的音频文件
+	audioDest := filepath.Join(summaryDir, item.Title+"_audio"+filepath.Ext(item.Audio))
+	if !utils.IsExist(audioDest) {
+		if err := copyFile(ctx, item.Audio, audioDest); err == nil {
+			logrus.Info("已将未合并的音频文件放入汇总目录: ", audioDest)
+		}
+	} else {
+		logrus.Warn("未合并的音频文件已存在，跳过复制: ", audioDest)
+	}
+}
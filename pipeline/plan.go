@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// intermediate 扫描时修复出的中间文件（-video.mp4、-audio.mp3），
+// 演练模式下只记录来源，读取时直接从来源文件中得到与实际写入相同的内容
+type intermediate struct {
+	dst    string
+	src    string // 直接复制的来源文件
+	offset int64  // 复制时跳过的头部字节数
+	// tracks 需要重新封装时返回写入的轨道，如blv分段或同时包含音视频的文件
+	tracks  func() ([]*mp4.Track, io.Closer, error)
+	sources []string // 重新封装时的来源文件，用于判断中间文件是否需要重新生成
+}
+
+// m4sOffset m4s文件头部的填充字节数，与copyFile的处理一致
+func m4sOffset(src string) int64 {
+	if bytes.Equal(readHead(src, 9), []byte("000000000")) {
+		return 9
+	}
+	return 0
+}
+
+// fresh 中间文件已存在且不早于来源文件，直接复制时大小也一致，无需重新生成
+func (m *intermediate) fresh() bool {
+	dst, err := os.Stat(m.dst)
+	if err != nil || dst.Size() == 0 {
+		return false
+	}
+	sources := m.sources
+	if m.src != "" {
+		src, err := os.Stat(m.src)
+		if err != nil || src.Size()-m.offset != dst.Size() {
+			return false
+		}
+		sources = append(sources, m.src)
+	}
+	for _, name := range sources {
+		src, err := os.Stat(name)
+		if err != nil || src.ModTime().After(dst.ModTime()) {
+			return false
+		}
+	}
+	return len(sources) > 0
+}
+
+// allFresh 所有中间文件都无需重新生成
+func allFresh(files []*intermediate) bool {
+	for _, m := range files {
+		if !m.fresh() {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// write 生成中间文件，progress不为空时报告写入的字节数
+func (m *intermediate) write(ctx context.Context, progress func(n, total int64)) error {
+	if m.tracks == nil {
+		return m.copy(ctx, progress)
+	}
+	tracks, c, err := m.tracks()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	var total int64
+	for _, name := range m.sources {
+		total += Size(name)
+	}
+	return createFile(ctx, m.dst, total, progress, func(w io.Writer) error {
+		return mp4.Write(w, tracks, mp4.Tags{})
+	})
+}
+
+func (m *intermediate) copy(ctx context.Context, progress func(n, total int64)) error {
+	f, err := os.Open(m.src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return copySection(ctx, io.NewSectionReader(f, m.offset, st.Size()-m.offset), m.dst, progress)
+}
+
+// open 解析中间文件的内容
+func (m *intermediate) open() ([]*mp4.Track, io.Closer, error) {
+	if m.tracks != nil {
+		return m.tracks()
+	}
+	f, err := os.Open(m.src)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	file, err := mp4.Parse(io.NewSectionReader(f, m.offset, st.Size()-m.offset), st.Size()-m.offset)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return file.Tracks, f, nil
+}
+
+// writeTo 将中间文件的内容写入w，用于计算哈希
+func (m *intermediate) writeTo(w io.Writer) error {
+	if m.tracks == nil {
+		f, err := os.Open(m.src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err = f.Seek(m.offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		return err
+	}
+	tracks, c, err := m.tracks()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return mp4.Write(w, tracks, mp4.Tags{})
+}
+
+// produce 实际运行时生成中间文件，演练模式下只记录。已是最新的中间文件不再重新生成
+func (s *Scanner) produce(ctx context.Context, m *intermediate) error {
+	if m.fresh() {
+		return nil
+	}
+	if s.DryRun {
+		if s.pending == nil {
+			s.pending = map[string]*intermediate{}
+		}
+		s.pending[m.dst] = m
+		return nil
+	}
+	var progress func(n, total int64)
+	if s.events != nil {
+		progress = func(n, total int64) { s.events.copy(m.dst, n, total) }
+	}
+	return m.write(ctx, progress)
+}
+
+// pendingIn 演练模式下目录中尚未生成的、指定后缀的中间文件，按文件名排序
+func (s *Scanner) pendingIn(dir, suffix string) []string {
+	var files []string
+	for dst := range s.pending {
+		if filepath.Dir(dst) == dir && strings.HasSuffix(dst, suffix) {
+			files = append(files, dst)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// OpenTracks 读取已修复或演练模式下待生成的音视频文件的轨道
+func (s *Scanner) OpenTracks(file string) ([]*mp4.Track, io.Closer, error) {
+	if m, ok := s.pending[file]; ok {
+		return m.open()
+	}
+	m, err := mp4.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Tracks, m, nil
+}
+
+// CombinedHash 计算音视频文件的组合哈希，演练模式下待生成的文件从来源读取。ctx取消时返回空字符串
+func (s *Scanner) CombinedHash(ctx context.Context, video, audio string) string {
+	if s.pending[video] == nil && s.pending[audio] == nil {
+		return calculateCombinedHash(ctx, video, audio)
+	}
+	hash := md5.New()
+	for _, file := range []string{video, audio} {
+		var err error
+		if m, ok := s.pending[file]; ok {
+			err = m.writeTo(hash)
+		} else {
+			err = copyTo(hash, file)
+		}
+		if err != nil {
+			return ""
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func copyTo(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"fmt"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 音频流类型
+const (
+	AudioAAC   = "aac"
+	AudioFLAC  = "flac"  // Hi-Res无损，dash.flac.audio
+	AudioDolby = "dolby" // 杜比全景声，dash.dolby.audio
+)
+
+// 音频流选择策略
+const (
+	AudioPreferBest  = "best" // 依次选择无损、杜比、AAC
+	AudioPreferFLAC  = AudioFLAC
+	AudioPreferDolby = AudioDolby
+	AudioPreferAAC   = AudioAAC
+)
+
+// AudioStream .playurl 中的一个音频流
+type AudioStream struct {
+	ID        string
+	Kind      string // aac、flac、dolby
+	Codecs    string // 如 mp4a.40.2、fLaC、ec-3
+	Bandwidth int64
+}
+
+// PlayUrl .playurl 中的音视频流信息
+type PlayUrl struct {
+	VideoID  string
+	Video    []VideoStream
+	Audio    []AudioStream
+	Duration time.Duration // 视频时长，取timelength，没有时取dash.duration，都没有时为0
+}
+
+// ReadPlayUrl 解析.playurl文件
+func ReadPlayUrl(path string) (*PlayUrl, error) {
+	data, err := bilicache.ReadPlayUrlData(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, path)
+	}
+	dash := data.Dash
+	pu := &PlayUrl{Duration: time.Duration(data.Timelength) * time.Millisecond}
+	if pu.Duration == 0 {
+		pu.Duration = time.Duration(dash.Duration) * time.Second
+	}
+	if n := len(dash.Video); n > 0 {
+		pu.VideoID = dash.Video[n-1].ID.String()
+	}
+	for _, v := range dash.Video {
+		pu.Video = append(pu.Video, VideoStream{
+			ID:        v.ID.String(),
+			Codec:     videoCodec(v.Codecs),
+			Width:     v.Width,
+			Height:    v.Height,
+			Bandwidth: v.Bandwidth,
+		})
+	}
+	add := func(kind string, streams ...bilicache.DashStream) {
+		for _, s := range streams {
+			if s.ID != "" {
+				pu.Audio = append(pu.Audio, AudioStream{ID: s.ID.String(), Kind: kind, Codecs: s.Codecs, Bandwidth: s.Bandwidth})
+			}
+		}
+	}
+	add(AudioAAC, dash.Audio...)
+	if flac := dash.FlacAudio(); flac != nil {
+		add(AudioFLAC, *flac)
+	}
+	add(AudioDolby, dash.DolbyAudio()...)
+	return pu, nil
+}
+
+// SelectAudio 在已缓存的音频流中按策略选择一个，present判断对应的m4s文件是否存在。
+// 都不存在时沿用原来的规则，返回 dash.audio 的最后一项
+func (p *PlayUrl) SelectAudio(prefer string, present func(id string) bool) *AudioStream {
+	order := []string{AudioFLAC, AudioDolby, AudioAAC}
+	if i := slices.Index(order, prefer); i > 0 {
+		order = append([]string{prefer}, slices.Delete(order, i, i+1)...)
+	}
+	for _, kind := range order {
+		var best *AudioStream
+		for i, s := range p.Audio {
+			if s.Kind == kind && present(s.ID) && (best == nil || s.Bandwidth > best.Bandwidth) {
+				best = &p.Audio[i]
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	for i := len(p.Audio) - 1; i >= 0; i-- {
+		if p.Audio[i].Kind == AudioAAC {
+			return &p.Audio[i]
+		}
+	}
+	return nil
+}
+
+// matchM4s 文件名是否属于指定的流，PC端为 xxx-1-<id>.m4s，Android端为 video.m4s、audio.m4s
+func matchM4s(name, id string) bool {
+	return name == id || name == id+conver.M4sSuffix || strings.HasSuffix(name, "-"+id+conver.M4sSuffix)
+}
+
+// m4sPresent 返回判断目录中是否存在指定流的m4s文件的函数
+func m4sPresent(dir string) func(id string) bool {
+	entries, _ := os.ReadDir(dir)
+	return func(id string) bool {
+		return slices.ContainsFunc(entries, func(e os.DirEntry) bool {
+			return !e.IsDir() && matchM4s(e.Name(), id)
+		})
+	}
+}
+
+// playUrlPath m4s文件所在目录的.playurl文件
+func playUrlPath(m4s string) string {
+	return filepath.Join(filepath.Dir(m4s), conver.PlayUrlSuffix)
+}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// parallel 最多使用jobs个协程，对0到n-1依次调用fn，ctx取消后不再开始新的调用
+func parallel(ctx context.Context, jobs, n int, fn func(i int)) {
+	jobs = max(1, min(jobs, n))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+}
@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event 运行过程中的进度事件，通过 Pipeline.OnEvent 订阅，类型为 CopyEvent、MuxEvent 或 ItemEvent
+type Event interface {
+	event()
+}
+
+// Progress 一次运行的整体进度
+type Progress struct {
+	Done    int           // 已处理完成的条目数，合成视频和导出音频分别计数
+	Total   int           // 需要处理的条目数
+	Percent float64       // 按输入文件大小加权的整体进度，0-100
+	ETA     time.Duration // 估算的剩余时间，无法估算时为0
+}
+
+// CopyEvent 扫描时修复m4s等中间文件的写入进度
+type CopyEvent struct {
+	File  string // 正在写入的中间文件
+	Bytes int64  // 已写入的字节数
+	Total int64  // 预计写入的字节数
+}
+
+// MuxEvent 合成视频或导出音频的进度
+type MuxEvent struct {
+	Output   string
+	Percent  float64 // 当前条目的进度，0-100，从合成后端的输出中解析或按写入的字节数估算
+	Progress Progress
+}
+
+// ItemEvent 单个条目处理完成
+type ItemEvent struct {
+	Result   ItemResult
+	Progress Progress
+}
+
+func (CopyEvent) event() {}
+func (MuxEvent) event()  {}
+func (ItemEvent) event() {}
+
+// tracker 汇总并发任务的进度，依次调用事件回调
+type tracker struct {
+	mu      sync.Mutex
+	on      func(Event)
+	begin   time.Time
+	sizes   []int64   // 每个任务的输入文件大小，跳过的任务为0
+	percent []float64 // 每个任务的进度
+	done    int
+}
+
+func newTracker(on func(Event)) *tracker {
+	return &tracker{on: on}
+}
+
+// start 开始执行任务，sizes为每个任务的输入文件大小
+func (t *tracker) start(sizes []int64) {
+	if t.on == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.begin, t.sizes, t.percent, t.done = time.Now(), sizes, make([]float64, len(sizes)), 0
+}
+
+// copy 报告中间文件的写入进度
+func (t *tracker) copy(file string, n, total int64) {
+	if t.on == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.on(CopyEvent{File: file, Bytes: n, Total: total})
+}
+
+// mux 报告第i个任务的进度
+func (t *tracker) mux(i int, output string, percent float64) {
+	if t.on == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	percent = min(max(percent, 0), 100)
+	if percent <= t.percent[i] {
+		return
+	}
+	t.percent[i] = percent
+	t.on(MuxEvent{Output: output, Percent: percent, Progress: t.progress()})
+}
+
+// finish 第i个任务处理完成
+func (t *tracker) finish(i int, r ItemResult) {
+	if t.on == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.percent[i] = 100
+	t.done++
+	t.on(ItemEvent{Result: r, Progress: t.progress()})
+}
+
+// progress 计算整体进度，调用时需持有锁
+func (t *tracker) progress() Progress {
+	p := Progress{Done: t.done, Total: len(t.sizes)}
+	var total, done float64
+	for i, size := range t.sizes {
+		total += float64(size)
+		done += float64(size) * t.percent[i] / 100
+	}
+	switch {
+	case total > 0:
+		p.Percent = done / total * 100
+	case p.Total > 0:
+		p.Percent = float64(p.Done) / float64(p.Total) * 100
+	}
+	if p.Percent > 0 && p.Percent < 100 {
+		elapsed := time.Since(t.begin)
+		p.ETA = time.Duration(float64(elapsed) * (100 - p.Percent) / p.Percent).Round(time.Second)
+	}
+	return p
+}
+
+// progressWriter 统计写入的字节数，每写入约0.5%调用一次fn
+type progressWriter struct {
+	w        io.Writer
+	n, total int64
+	last     int64
+	fn       func(n, total int64)
+}
+
+func newProgressWriter(w io.Writer, total int64, fn func(n, total int64)) io.Writer {
+	if fn == nil {
+		return w
+	}
+	return &progressWriter{w: w, total: total, fn: fn}
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	if w.n-w.last >= max(w.total/200, 64<<10) {
+		w.last = w.n
+		w.fn(w.n, max(w.total, w.n))
+	}
+	return n, err
+}
+
+// percentOf 已写入字节数占预计大小的百分比，写入完成前最多为99
+func percentOf(n, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return min(float64(n)/float64(total)*100, 99)
+}
+
+// lineWriter 保存外部程序的全部输出用于出错时记录日志，同时将以\r或\n分隔的每一行交给fn解析进度
+type lineWriter struct {
+	bytes.Buffer
+	line []byte
+	fn   func(line string)
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	n, _ := w.Buffer.Write(b)
+	if w.fn == nil {
+		return n, nil
+	}
+	for _, c := range b {
+		if c == '\r' || c == '\n' {
+			if len(w.line) > 0 {
+				w.fn(string(w.line))
+			}
+			w.line = w.line[:0]
+			continue
+		}
+		w.line = append(w.line, c)
+	}
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"m4s-converter/internal"
+	"os"
+	"slices"
+	"time"
+)
+
+// Report 运行报告，用于脚本读取每个条目的处理结果
+type Report struct {
+	CachePath  string       `json:"cachePath"`
+	OutputDir  string       `json:"outputDir"`
+	Begin      time.Time    `json:"begin"`
+	End        time.Time    `json:"end"`
+	Elapsed    float64      `json:"elapsed"` // 总耗时，秒
+	Error      string       `json:"error,omitempty"`
+	Total      int          `json:"total"`
+	Converted  int          `json:"converted"`
+	Skipped    int          `json:"skipped"`
+	Failed     int          `json:"failed"`
+	Planned    int          `json:"planned,omitempty"`
+	Unverified int          `json:"unverified"` // 合成后校验未通过的条目数，包括因此被删除或隔离的条目
+	Items      []ReportItem `json:"items"`
+}
+
+// ReportItem 单个条目的处理结果
+type ReportItem struct {
+	Dir        string        `json:"dir"`
+	Title      string        `json:"title"`
+	Video      string        `json:"video,omitempty"`
+	Audio      string        `json:"audio,omitempty"`
+	Output     string        `json:"output,omitempty"`
+	Status     Status        `json:"status"`
+	Reason     string        `json:"reason,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	InputSize  int64         `json:"inputSize"`        // 视频和音频文件的大小之和，字节
+	OutputSize int64         `json:"outputSize"`       // 输出文件的大小，字节，文件不存在时为0
+	Duration   float64       `json:"duration"`         // 输出文件的播放时长，秒，无法读取时为0
+	Elapsed    float64       `json:"elapsed"`          // 处理耗时，秒
+	Verify     *VerifyReport `json:"verify,omitempty"` // 合成后的校验结果，未合成时为空
+}
+
+// VerifyReport 合成后对输出文件的校验结果，时长单位为秒，未知时为0
+type VerifyReport struct {
+	OK          bool     `json:"ok"`
+	VideoTracks int      `json:"videoTracks"`
+	AudioTracks int      `json:"audioTracks"`
+	Video       float64  `json:"video"`              // 输出文件中视频轨道的时长
+	Audio       float64  `json:"audio"`              // 输出文件中音频轨道的时长
+	SrcVideo    float64  `json:"srcVideo"`           // 源视频文件的时长
+	SrcAudio    float64  `json:"srcAudio"`           // 源音频文件的时长
+	Expected    float64  `json:"expected,omitempty"` // .playurl中的视频时长
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// Report 生成运行报告，err为运行中断时的错误
+func (r *Result) Report(err error) *Report {
+	rep := &Report{
+		CachePath: r.CachePath,
+		OutputDir: r.OutputDir,
+		Begin:     r.Begin,
+		End:       r.End,
+		Elapsed:   r.End.Sub(r.Begin).Seconds(),
+		Total:     len(r.Items),
+		Converted: len(r.Filter(StatusConverted)),
+		Skipped:   len(r.Filter(StatusSkipped)),
+		Failed:    len(r.Filter(StatusFailed)),
+		Planned:   len(r.Filter(StatusPlanned)),
+		Items:     []ReportItem{},
+	}
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	for _, v := range r.Items {
+		rep.Items = append(rep.Items, NewReportItem(v))
+		if !v.Verify.OK() {
+			rep.Unverified++
+		}
+	}
+	return rep
+}
+
+// NewReportItem 生成单个条目的报告
+func NewReportItem(v ItemResult) ReportItem {
+	item := ReportItem{
+		Dir:        v.Item.Dir,
+		Title:      v.Item.Name(),
+		Video:      v.Item.Video,
+		Audio:      v.Item.Audio,
+		Output:     v.Output,
+		Status:     v.Status,
+		Reason:     v.Reason,
+		InputSize:  Size(v.Item.Video) + Size(v.Item.Audio),
+		OutputSize: Size(v.Output),
+		Elapsed:    v.Elapsed.Seconds(),
+	}
+	if v.Err != nil {
+		item.Error = v.Err.Error()
+	}
+	if item.OutputSize > 0 {
+		item.Duration = duration(v.Output).Seconds()
+	}
+	if f := v.Verify; f != nil {
+		item.Verify = &VerifyReport{
+			OK:          f.OK(),
+			VideoTracks: f.VideoTracks,
+			AudioTracks: f.AudioTracks,
+			Video:       f.Video.Seconds(),
+			Audio:       f.Audio.Seconds(),
+			SrcVideo:    f.SrcVideo.Seconds(),
+			SrcAudio:    f.SrcAudio.Seconds(),
+			Expected:    f.Expected.Seconds(),
+			Problems:    f.Problems,
+		}
+	}
+	return item
+}
+
+// WriteReport 将运行报告写为JSON文件
+func (r *Result) WriteReport(file string, err error) error {
+	b, e := json.MarshalIndent(r.Report(err), "", "  ")
+	if e != nil {
+		return e
+	}
+	return internal.WriteFile(file, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	})
+}
+
+// duration 文件中最长的流的时长
+func duration(file string) time.Duration {
+	streams, err := probeFile(file)
+	if err != nil {
+		return 0
+	}
+	var d []time.Duration
+	for _, s := range streams {
+		d = append(d, s.Duration)
+	}
+	if len(d) == 0 {
+		return 0
+	}
+	return slices.Max(d)
+}
@@ -0,0 +1,374 @@
+package pipeline
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/mzky/utils/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Scanner 查找缓存目录下可转换的条目
+type Scanner struct {
+	CachePath string
+	AssOFF    bool   // 关闭自动生成弹幕
+	Audio     string // 音频流选择策略: best(默认)、flac、dolby、aac
+	Video     string // 视频流选择策略，见 ParsePrefer，为空时使用 DefaultPrefer
+	DryRun    bool   // 演练模式，不修复m4s、不下载弹幕，只记录待生成的中间文件
+	Jobs      int    // 同时解析条目和下载弹幕的数量，小于1时为1
+
+	pending map[string]*intermediate // 演练模式下待生成的中间文件
+	events  *tracker                 // 报告中间文件的写入进度，由 Pipeline.Run 设置
+}
+
+// Scan 将m4s修复为音视频文件，并返回缓存目录下的所有条目
+func (s *Scanner) Scan(ctx context.Context) ([]*Item, error) {
+	logrus.Println("查找缓存目录下可转换的文件...")
+	// 查找m4s文件，并转换为mp4和mp3
+	if err := filepath.WalkDir(s.CachePath, func(path string, d os.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return s.FindM4sFiles(ctx, path, d, err)
+	}); err != nil {
+		return nil, fmt.Errorf("查找并转换 m4s 文件异常：%w", err)
+	}
+
+	dirs, err := GetCacheDir(s.CachePath) // 缓存根目录模式
+	if err != nil {
+		return nil, fmt.Errorf("找不到 BiliBili 的缓存目录：%v", err)
+	}
+
+	if dirs == nil {
+		// 判断非缓存根目录时，验证是否为子目录
+		if infoFile(s.CachePath) != "" {
+			dirs = append(dirs, s.CachePath)
+		}
+	}
+
+	// 并发解析条目和下载弹幕，结果保持目录顺序
+	found := make([]*Item, len(dirs))
+	parallel(ctx, s.Jobs, len(dirs), func(i int) {
+		item, e := s.Item(ctx, dirs[i])
+		if e != nil {
+			logrus.Error(e)
+			return
+		}
+		if item != nil {
+			// 清晰度只在输出路径模板用到时读取视频文件，每个条目只读取一次
+			item.quality = sync.OnceValue(func() string {
+				v, err := s.probeVideo(item.Video)
+				if err != nil {
+					return ""
+				}
+				return qualityLabel(v.Height)
+			})
+		}
+		found[i] = item
+	})
+	var items []*Item
+	for _, item := range found {
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+	return items, ctx.Err()
+}
+
+// Item 解析单个缓存目录，目录中没有视频信息文件时返回nil
+func (s *Scanner) Item(ctx context.Context, dir string) (*Item, error) {
+	info := infoFile(dir)
+	if info == "" {
+		return nil, nil
+	}
+	if filepath.Base(info) == conver.PlayEntryJson {
+		return s.androidItem(ctx, dir, info)
+	}
+	video, audio, e := s.audioAndVideo(dir)
+	if e != nil {
+		return nil, fmt.Errorf("找不到已修复的音频和视频文件: %v", e)
+	}
+	if video, e = s.selectVideo(video); e != nil {
+		return nil, e
+	}
+	meta, e := bilicache.ReadVideoMeta(info)
+	if e != nil {
+		return nil, e
+	}
+	item := newItem(dir, info, video, audio, meta)
+	dirPath := filepath.Dir(video)
+	item.Cid = cmp.Or(meta.Cid, filepath.Base(dirPath))
+	if pu, err := ReadPlayUrl(playUrlPath(video)); err == nil {
+		item.Duration = pu.Duration
+	}
+
+	// 下载弹幕文件
+	if !s.AssOFF && !s.DryRun {
+		item.AssPath = downloadXml(ctx, filepath.Join(dirPath, item.Cid+conver.XmlSuffix), item.Cid)
+	}
+	return item, nil
+}
+
+// infoFile 返回目录中的视频信息文件，依次查找 videoInfo.json、.videoInfo、entry.json 和UWP的 .info
+func infoFile(dir string) string {
+	for _, name := range []string{conver.VideoInfoJson, conver.VideoInfoSuffix, conver.PlayEntryJson} {
+		if info := filepath.Join(dir, name); utils.IsExist(info) {
+			return info
+		}
+	}
+	return uwpInfo(dir)
+}
+
+// newItem 由统一的视频信息生成条目
+func newItem(dir, info, video, audio string, meta *bilicache.VideoMeta) *Item {
+	item := &Item{Dir: dir, InfoFile: info, Video: video, Audio: audio}
+	item.GroupTitle = Filter(meta.GroupTitle, nil)
+	item.Title = Filter(meta.Title, nil)
+	item.Part = Filter(meta.Part, nil)
+	item.Uname = Filter(meta.Uname, nil)
+	item.Status = Filter(meta.Status, nil)
+	item.ItemId = cmp.Or(meta.ItemId, "0")
+	item.GroupId = Filter(meta.GroupId, nil)
+	item.Uid = Filter(meta.Uid, nil)
+	item.Cid = meta.Cid
+	item.Bvid = meta.Bvid
+	item.Uploader = Filter(meta.Uploader, nil)
+	item.Page = meta.Page
+	if meta.PubDate > 0 {
+		item.PubDate = time.Unix(meta.PubDate, 0)
+	}
+
+	// 封面优先使用本地缓存的图片，其次使用URL
+	if meta.CoverPath != "" && utils.IsExist(meta.CoverPath) {
+		item.Cover = meta.CoverPath
+	} else {
+		item.Cover = meta.Cover
+	}
+	return item
+}
+
+// FindM4sFiles 将遍历到的m4s文件修复为音视频文件，ctx取消时中断复制并删除不完整的文件
+func (s *Scanner) FindM4sFiles(ctx context.Context, src string, info os.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+	// 旧版Android客户端的分段flv，整个目录一起转换
+	if info.IsDir() && HasBlvFiles(src) {
+		return s.FindBlvFiles(ctx, src)
+	}
+	// UWP客户端的缓存，去掉混淆字节
+	if info.IsDir() && IsUWPDir(src) {
+		return s.FindUWPFiles(ctx, src)
+	}
+	// Android客户端的清晰度目录没有.playurl，每个目录只判断一次是否为选中的清晰度
+	if info.IsDir() && !utils.IsExist(filepath.Join(src, conver.PlayUrlSuffix)) && hasM4s(src) && !androidSelected(src) {
+		return filepath.SkipDir
+	}
+	// 查找.m4s文件
+	if strings.HasSuffix(info.Name(), conver.M4sSuffix) {
+		var dst string
+		videoId, audioId, audioIds := GetVAId(src, s.Audio)
+		if videoId == "" || audioId == "" {
+			return nil // 未缓存完成或未选择的流，已在GetVAId中记录日志
+		}
+		switch {
+		case matchM4s(info.Name(), audioId): // 选中的音频文件
+			dst = strings.ReplaceAll(src, conver.M4sSuffix, conver.AudioSuffix)
+		case slices.ContainsFunc(audioIds, func(id string) bool { return matchM4s(info.Name(), id) }):
+			logrus.Info("跳过未选择的音频流: ", strings.TrimPrefix(src, s.CachePath))
+			return nil
+		default:
+			dst = strings.ReplaceAll(src, conver.M4sSuffix, conver.VideoSuffix)
+		}
+
+		m := &intermediate{dst: dst, src: src, offset: m4sOffset(src)}
+		if m.fresh() {
+			return nil // 上次运行已修复且m4s未变化
+		}
+		if err = s.produce(ctx, m); err != nil {
+			return fmt.Errorf("%v 转换异常：%w", src, err)
+		}
+		if s.DryRun {
+			return nil
+		}
+		logrus.Info("已将m4s转换为音视频文件: ", strings.TrimPrefix(dst, s.CachePath))
+	}
+	return nil
+}
+
+// selectVideo 目录中缓存了多个清晰度或编码的视频时，按策略选择一个
+func (s *Scanner) selectVideo(video string) (string, error) {
+	videos := s.listVideos(filepath.Dir(video))
+	if len(videos) < 2 {
+		return video, nil
+	}
+	prefer, err := ParsePrefer(cmp.Or(s.Video, DefaultPrefer))
+	if err != nil {
+		return "", err
+	}
+	logrus.Info("缓存中有多个视频流: ", filepath.Dir(video))
+	for _, v := range videos {
+		logrus.Infof("  %s: %s", filepath.Base(v.File), v)
+	}
+	selected := prefer.Select(videos)
+	logrus.Infof("选择视频流: %s (%s)", filepath.Base(selected.File), selected)
+	return selected.File, nil
+}
+
+// GetCacheDir 返回缓存根目录下包含视频信息文件的子目录，
+// Android缓存为entry.json所在的分P或剧集目录，不包括其中的清晰度目录
+func GetCacheDir(cachePath string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != cachePath {
+			if strings.Contains(path, "output") {
+				return filepath.SkipDir
+			}
+			if infoFile(path) != "" {
+				dirs = append(dirs, path)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+// audioAndVideo 查找音频和视频文件，演练模式下优先使用待生成的中间文件
+func (s *Scanner) audioAndVideo(dir string) (string, string, error) {
+	if len(s.pending) > 0 {
+		var video, audio string
+		_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || !d.IsDir() || video != "" && audio != "" {
+				return err
+			}
+			if v := s.pendingIn(path, conver.VideoSuffix); len(v) > 0 {
+				video = v[0]
+			}
+			if a := s.pendingIn(path, conver.AudioSuffix); len(a) > 0 {
+				audio = a[0]
+			}
+			return nil
+		})
+		if video != "" && audio != "" {
+			return video, audio, nil
+		}
+	}
+	return GetAudioAndVideo(dir)
+}
+
+// GetAudioAndVideo 从给定的缓存路径中查找音频和视频文件
+// 参数:
+// - cachePath: 缓存路径，用于搜索音频、视频文件
+// 返回值:
+// - video: 查找到的视频文件路径
+// - audio: 查找到的音频文件路径
+// - error: 在搜索过程中遇到的任何错误
+func GetAudioAndVideo(cachePath string) (string, string, error) {
+	var video, audio string
+
+	// 遍历给定路径下的所有文件（不包括子目录）
+	entries, err := os.ReadDir(cachePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			// 如果是目录，递归查找
+			childVideo, childAudio, err := GetAudioAndVideo(filepath.Join(cachePath, entry.Name()))
+			if err == nil && childVideo != "" && childAudio != "" {
+				video = childVideo
+				audio = childAudio
+				break
+			}
+			continue
+		}
+
+		// 如果是文件，检查是否为视频或音频文件
+		fileName := entry.Name()
+		if strings.HasSuffix(fileName, conver.VideoSuffix) {
+			video = filepath.Join(cachePath, fileName)
+		}
+		if strings.HasSuffix(fileName, conver.AudioSuffix) {
+			audio = filepath.Join(cachePath, fileName)
+		}
+	}
+
+	// 如果在当前目录及其子目录中都找不到视频或音频文件，返回错误
+	if video == "" || audio == "" {
+		return "", "", fmt.Errorf("找不到音频或视频文件: %s", cachePath)
+	}
+	return video, audio, nil // 返回找到的视频和音频文件路径
+}
+
+// GetVAId 返回.playurl文件中的视频流ID、按prefer选中的音频流ID和所有音频流ID
+func GetVAId(patch, prefer string) (videoID string, audioID string, audioIDs []string) {
+	pu := playUrlPath(patch)
+	if utils.IsExist(pu) {
+		/*
+			视频：
+			data.dash.video[0].id
+			data.dash.audio[0].id
+			番剧：
+			result.dash.video[0].id  80  需要加上30000，实际30080.m4s
+			result.dash.audio[0].id  30280
+			无损和杜比音频：
+			data.dash.flac.audio.id  30251
+			data.dash.dolby.audio[0].id  30250
+		*/
+		p, err := ReadPlayUrl(pu)
+		if err != nil {
+			return "", "", nil
+		}
+		audio := p.SelectAudio(prefer, m4sPresent(filepath.Dir(patch)))
+		if audio == nil {
+			return p.VideoID, "", nil
+		}
+		for _, v := range p.Audio {
+			audioIDs = append(audioIDs, v.ID)
+		}
+		return p.VideoID, audio.ID, audioIDs
+	}
+	// Android客户端没有.playurl，清晰度目录已在 FindM4sFiles 中按entry.json选择
+	return "video.m4s", "audio.m4s", nil
+}
+
+// HasM4sFiles 检查目录及其子目录下是否存在m4s、blv或UWP客户端的缓存文件
+func HasM4sFiles(cachePath string) error {
+	var m4sFiles []string
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logrus.Warnf("查找bilibili缓存目录异常: %s", path)
+			return err
+		}
+		if ext := filepath.Ext(path); !info.IsDir() && (ext == conver.M4sSuffix || ext == conver.BlvSuffix) || info.IsDir() && IsUWPDir(path) {
+			m4sFiles = append(m4sFiles, path)
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(m4sFiles) == 0 {
+		return fmt.Errorf("缓存目录找不到m4s、blv或UWP缓存文件: %s", cachePath)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"m4s-converter/conver"
+	"m4s-converter/internal"
+	"m4s-converter/mkv"
+	"m4s-converter/mp4"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 弹幕字幕轨道的名称和语言
+const (
+	danmakuName     = "Danmaku"
+	danmakuLanguage = "chi"
+)
+
+// danmakuSubtitle 读取弹幕ass文件，生成MKV的字幕轨道，默认不显示
+func danmakuSubtitle(path string) (*mkv.Subtitle, error) {
+	ass, err := conver.ParseAss(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &mkv.Subtitle{
+		CodecID:  "S_TEXT/ASS",
+		Private:  []byte(ass.Header),
+		Name:     danmakuName,
+		Language: danmakuLanguage,
+	}
+	for _, e := range ass.Events {
+		s.Events = append(s.Events, mkv.Event{Start: e.Start, End: e.End, Data: []byte(e.MatroskaData())})
+	}
+	return s, nil
+}
+
+// danmakuCues 读取弹幕ass文件并转换为纯文本，MP4的文本轨道同一时间只能显示一个样本，
+// 同时出现的弹幕合并为多行
+func danmakuCues(path string) ([]mp4.TextCue, error) {
+	ass, err := conver.ParseAss(path)
+	if err != nil {
+		return nil, err
+	}
+	type line struct {
+		start, end time.Duration
+		text       string
+	}
+	var lines []line
+	var points []time.Duration
+	for _, e := range ass.Events {
+		text := e.PlainText()
+		if text == "" || e.End <= e.Start {
+			continue
+		}
+		lines = append(lines, line{e.Start, e.End, text})
+		points = append(points, e.Start, e.End)
+	}
+	slices.Sort(points)
+	points = slices.Compact(points)
+
+	// 按时间顺序扫描一遍开始和结束事件，active为当前显示的弹幕，按原始顺序排列
+	byStart, byEnd := make([]int, len(lines)), make([]int, len(lines))
+	for i := range lines {
+		byStart[i], byEnd[i] = i, i
+	}
+	slices.SortStableFunc(byStart, func(a, b int) int { return cmp.Compare(lines[a].start, lines[b].start) })
+	slices.SortStableFunc(byEnd, func(a, b int) int { return cmp.Compare(lines[a].end, lines[b].end) })
+	var active []int
+	var cues []mp4.TextCue
+	for i, s, e := 0, 0, 0; i+1 < len(points); i++ {
+		start, end := points[i], points[i+1]
+		for ; e < len(byEnd) && lines[byEnd[e]].end <= start; e++ {
+			if j, ok := slices.BinarySearch(active, byEnd[e]); ok {
+				active = slices.Delete(active, j, j+1)
+			}
+		}
+		for ; s < len(byStart) && lines[byStart[s]].start <= start; s++ {
+			j, _ := slices.BinarySearch(active, byStart[s])
+			active = slices.Insert(active, j, byStart[s])
+		}
+		if len(active) == 0 {
+			continue
+		}
+		texts := make([]string, len(active))
+		for j, l := range active {
+			texts[j] = lines[l].text
+		}
+		text := strings.Join(texts, "\n")
+		if n := len(cues); n > 0 && cues[n-1].End == start && cues[n-1].Text == text {
+			cues[n-1].End = end
+			continue
+		}
+		cues = append(cues, mp4.TextCue{Start: start, End: end, Text: text})
+	}
+	return cues, nil
+}
+
+// writeSrt 将文本写为srt字幕，供MP4Box和ffmpeg导入
+func writeSrt(path string, cues []mp4.TextCue) error {
+	return internal.WriteFile(path, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		for i, c := range cues {
+			_, _ = fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(c.Start), srtTime(c.End), c.Text)
+		}
+		return w.Flush()
+	})
+}
+
+// srtTime 格式化为 HH:MM:SS,mmm
+func srtTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// danmakuSrt 将弹幕转换为临时srt文件，合成结束后调用返回的函数删除。强制退出时也会删除该文件
+func danmakuSrt(job *Job) (string, func(), error) {
+	cues, err := danmakuCues(job.Subtitle)
+	if err != nil {
+		return "", nil, fmt.Errorf("读取弹幕文件失败: %s: %v", job.Subtitle, err)
+	}
+	srt := job.Output + ".srt"
+	done := internal.Writing(srt)
+	if err = writeSrt(srt, cues); err != nil {
+		done()
+		return "", nil, err
+	}
+	return srt, func() {
+		_ = os.Remove(srt)
+		done()
+	}, nil
+}
@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDanmakuCues(t *testing.T) {
+	ass := `[Script Info]
+ScriptType: v4.00+
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:01.00,0:00:04.00,R2L,,0,0,0,,{\move(10,20,30,40)}第一条
+Dialogue: 0,0:00:02.00,0:00:03.00,R2L,,0,0,0,,第二条
+Dialogue: 0,0:00:02.00,0:00:05.00,R2L,,0,0,0,,第三条
+Dialogue: 0,0:00:06.00,0:00:06.00,R2L,,0,0,0,,没有时长
+Dialogue: 0,0:00:07.00,0:00:08.00,R2L,,0,0,0,,第四条
+Dialogue: 0,0:00:08.00,0:00:09.00,R2L,,0,0,0,,第四条
+`
+	path := filepath.Join(t.TempDir(), "a.ass")
+	if err := os.WriteFile(path, []byte(ass), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cues, err := danmakuCues(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := time.Second
+	want := []mp4.TextCue{
+		{Start: 1 * s, End: 2 * s, Text: "第一条"},
+		{Start: 2 * s, End: 3 * s, Text: "第一条\n第二条\n第三条"},
+		{Start: 3 * s, End: 4 * s, Text: "第一条\n第三条"},
+		{Start: 4 * s, End: 5 * s, Text: "第三条"},
+		{Start: 7 * s, End: 9 * s, Text: "第四条"},
+	}
+	if !reflect.DeepEqual(cues, want) {
+		t.Fatalf("字幕为 %q", cues)
+	}
+}
@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"m4s-converter/internal"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utils "github.com/mzky/utils/common"
+	"github.com/sirupsen/logrus"
+)
+
+// RemovePartialFiles 删除所有正在写入的临时文件，用于再次按Ctrl+C强制退出前清理
+func RemovePartialFiles() {
+	internal.RemovePartialFiles()
+}
+
+// contextWriter ctx取消后写入返回错误，用于中断正在进行的复制和封装
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w contextWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(b)
+}
+
+// createFile 由write写入文件，progress不为空时按预计大小total报告写入的字节数。
+// 先写入同目录的临时文件，ctx取消或写入失败时不会留下不完整的目标文件
+func createFile(ctx context.Context, name string, total int64, progress func(n, total int64), write func(io.Writer) error) error {
+	return internal.WriteFile(name, func(f *os.File) error {
+		return write(newProgressWriter(contextWriter{ctx, f}, total, progress))
+	})
+}
+
+// copyFile 复制文件，去掉m4s头部的填充字节。先写入同目录的临时文件，ctx取消或复制失败时不会留下不完整的目标文件
+func copyFile(ctx context.Context, src, dst string) error {
+	// 打开源文件
+	srcFile, err := os.Open(src)
+	if err != nil {
+		logrus.Errorf("打开源文件失败: %v", err)
+		return err
+	}
+	defer srcFile.Close()
+
+	return internal.WriteFile(dst, func(dstFile *os.File) error {
+		// 读取前 9 个字节
+		data := make([]byte, 9)
+		if _, err := io.ReadAtLeast(srcFile, data, 9); err != nil {
+			logrus.Errorf("读取文件头失败: %v", err)
+			return err
+		}
+
+		// 检查前 9 个字节是否为 '0'
+		if string(data) != "000000000" {
+			// 如果前 9 个字节不为 '0'，写入这些字节
+			if _, err := dstFile.Write(data); err != nil {
+				logrus.Errorf("写入文件头失败: %v", err)
+				return err
+			}
+		}
+
+		// 使用缓冲读取器逐块读取并写入文件
+		w := bufio.NewWriter(contextWriter{ctx, dstFile})
+		if _, err := io.Copy(w, bufio.NewReader(srcFile)); err != nil {
+			logrus.Errorf("读取或写入文件失败: %v", err)
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// M4sToAV 去掉m4s文件头部的填充字节，修复为可识别的音视频文件
+func M4sToAV(src, dst string) error {
+	// 确保目标目录存在
+	dstDir := filepath.Dir(dst)
+	if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		logrus.Errorf("创建目标目录失败: %v", err)
+		return err
+	}
+	return copyFile(context.Background(), src, dst)
+}
+
+func Size(path string) int64 {
+	if utils.IsExist(path) {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return 0
+		}
+		return fileInfo.Size()
+	}
+	return 0
+}
+
+// Filter 过滤文件名
+func Filter(name string, err error) string {
+	if err != nil || name == "" {
+		return ""
+	}
+	name = strings.ReplaceAll(name, "（", "(")
+	name = strings.ReplaceAll(name, "）", ")")
+	name = strings.ReplaceAll(name, "<", "《")
+	name = strings.ReplaceAll(name, ">", "》")
+	name = strings.ReplaceAll(name, `\`, "#")
+	name = strings.ReplaceAll(name, `"`, `'`)
+	name = strings.ReplaceAll(name, "/", "#")
+	name = strings.ReplaceAll(name, "|", "_")
+	name = strings.ReplaceAll(name, "?", "？")
+	name = strings.ReplaceAll(name, "*", "-")
+	name = strings.ReplaceAll(name, "【", "[")
+	name = strings.ReplaceAll(name, "】", "]")
+	name = strings.ReplaceAll(name, ":", "：")
+	name = strings.ReplaceAll(name, " ", "_")
+
+	return strings.TrimSpace(name)
+}
+
+// abs 计算整数的绝对值
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// calculateCombinedHash 计算音频和视频文件的组合哈希值（流式计算），ctx取消时返回空字符串
+func calculateCombinedHash(ctx context.Context, videoPath string, audioPath string) string {
+	hash := md5.New()
+
+	// 计算视频文件哈希（流式）
+	videoFile, err := os.Open(videoPath)
+	if err == nil {
+		// 使用流式读取，每次读取4KB
+		buffer := make([]byte, 4096)
+		for {
+			if ctx.Err() != nil {
+				videoFile.Close()
+				return ""
+			}
+			n, readErr := videoFile.Read(buffer)
+			if readErr != nil && readErr != io.EOF {
+				logrus.Errorf("读取视频文件失败: %v", readErr)
+				videoFile.Close()
+				return ""
+			}
+			if n == 0 {
+				break
+			}
+			// 只更新实际读取的数据
+			hash.Write(buffer[:n])
+		}
+		videoFile.Close()
+	} else {
+		logrus.Errorf("打开视频文件失败: %v", err)
+		return ""
+	}
+
+	// 计算音频文件哈希（流式）
+	audioFile, err := os.Open(audioPath)
+	if err == nil {
+		// 使用流式读取，每次读取4KB
+		buffer := make([]byte, 4096)
+		for {
+			if ctx.Err() != nil {
+				audioFile.Close()
+				return ""
+			}
+			n, readErr := audioFile.Read(buffer)
+			if readErr != nil && readErr != io.EOF {
+				logrus.Errorf("读取音频文件失败: %v", readErr)
+				audioFile.Close()
+				return ""
+			}
+			if n == 0 {
+				break
+			}
+			// 只更新实际读取的数据
+			hash.Write(buffer[:n])
+		}
+		audioFile.Close()
+	} else {
+		logrus.Errorf("打开音频文件失败: %v", err)
+		return ""
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
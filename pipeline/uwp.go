@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// uwpMagic UWP客户端缓存的音视频文件头部的混淆字节
+var uwpMagic = []byte{0xFF, 0xFF, 0xFF}
+
+// UWPMediaFiles 返回目录中带有混淆字节的UWP音视频文件，按文件名排序
+func UWPMediaFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasSuffix(name, conver.VideoSuffix) || strings.HasSuffix(name, conver.AudioSuffix) {
+			continue
+		}
+		if ext := filepath.Ext(name); ext != conver.Mp4Suffix && ext != ".flv" {
+			continue
+		}
+		if file := filepath.Join(dir, name); bytes.Equal(readHead(file, len(uwpMagic)), uwpMagic) {
+			files = append(files, file)
+		}
+	}
+	slices.SortFunc(files, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b) // 分段序号 _9 排在 _10 之前
+		}
+		return strings.Compare(a, b)
+	})
+	return files
+}
+
+// uwpInfo 返回UWP分P目录中的 .info 文件，只有包含音视频文件的目录才是分P目录
+func uwpInfo(dir string) string {
+	infos, _ := filepath.Glob(filepath.Join(dir, "*"+bilicache.UWPInfoSuffix))
+	if len(infos) == 0 {
+		return ""
+	}
+	if len(UWPMediaFiles(dir)) == 0 && !hasConverted(dir) {
+		return ""
+	}
+	return infos[0]
+}
+
+// IsUWPDir 目录是否为待修复的UWP分P目录
+func IsUWPDir(dir string) bool {
+	return len(UWPMediaFiles(dir)) > 0 && uwpInfo(dir) != ""
+}
+
+// hasConverted 目录中是否有已修复的视频文件
+func hasConverted(dir string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+conver.VideoSuffix))
+	return len(files) > 0
+}
+
+// readHead 读取文件开头的n个字节
+func readHead(file string, n int) []byte {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	b := make([]byte, n)
+	if _, err = io.ReadFull(f, b); err != nil {
+		return nil
+	}
+	return b
+}
+
+// uwpSection 去掉混淆字节后的文件内容
+func uwpSection(f *os.File) (*io.SectionReader, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	n := int64(len(uwpMagic))
+	return io.NewSectionReader(f, n, st.Size()-n), nil
+}
+
+// uwpIntermediates 返回UWP音视频文件修复出的中间文件。DASH缓存的音视频分别为一个mp4文件，
+// 只有一个轨道时直接去掉混淆字节；旧版缓存为一组flv分段，需要拼接后拆分音视频
+func uwpIntermediates(dir string) ([]*intermediate, error) {
+	files := UWPMediaFiles(dir)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("找不到UWP音视频文件: %s", dir)
+	}
+	var result []*intermediate
+	var segments []string
+	for _, file := range files {
+		if h := readHead(file, len(uwpMagic)+3); len(h) > 0 && string(h[len(uwpMagic):]) == "FLV" {
+			segments = append(segments, file)
+			continue
+		}
+		m, err := uwpMP4(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		result = append(result, m...)
+	}
+	if len(segments) > 0 {
+		base := strings.TrimSuffix(segments[0], filepath.Ext(segments[0]))
+		result = append(result, splitFLV(segments, uwpSection, base, base)...)
+	}
+	return result, nil
+}
+
+// uwpMP4 按文件中的轨道判断音视频
+func uwpMP4(file string) ([]*intermediate, error) {
+	open := func() (*mp4.File, *os.File, error) {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := uwpSection(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, err
+		}
+		m, err := mp4.Parse(r, r.Size())
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, err
+		}
+		return m, f, nil
+	}
+	m, f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	video, audio := m.Track(mp4.Video) != nil, m.Track(mp4.Audio) != nil
+	_ = f.Close()
+
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	offset := int64(len(uwpMagic))
+	track := func(handler string) func() ([]*mp4.Track, io.Closer, error) {
+		return func() ([]*mp4.Track, io.Closer, error) {
+			m, f, err := open()
+			if err != nil {
+				return nil, nil, err
+			}
+			return []*mp4.Track{m.Track(handler)}, f, nil
+		}
+	}
+	switch {
+	case video && audio:
+		return []*intermediate{
+			{dst: base + conver.VideoSuffix, tracks: track(mp4.Video), sources: []string{file}},
+			{dst: base + conver.AudioSuffix, tracks: track(mp4.Audio), sources: []string{file}},
+		}, nil
+	case video:
+		return []*intermediate{{dst: base + conver.VideoSuffix, src: file, offset: offset}}, nil
+	case audio:
+		return []*intermediate{{dst: base + conver.AudioSuffix, src: file, offset: offset}}, nil
+	}
+	return nil, fmt.Errorf("文件中找不到音视频轨道")
+}
+
+// copySection 将r的全部内容写入dst
+func copySection(ctx context.Context, r *io.SectionReader, dst string, progress func(n, total int64)) error {
+	return createFile(ctx, dst, r.Size(), progress, func(w io.Writer) error {
+		_, err := io.Copy(w, io.NewSectionReader(r, 0, r.Size()))
+		return err
+	})
+}
+
+// FindUWPFiles 将UWP客户端分P目录中的音视频文件修复为可识别的文件
+func (s *Scanner) FindUWPFiles(ctx context.Context, dir string) error {
+	files, err := uwpIntermediates(dir)
+	if err == nil && allFresh(files) {
+		return nil
+	}
+	logrus.Info("修复UWP客户端缓存: ", strings.TrimPrefix(dir, s.CachePath))
+	if err == nil {
+		for _, m := range files {
+			if err = s.produce(ctx, m); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		logrus.Errorf("%v 转换异常：%v", dir, err)
+	}
+	return nil
+}
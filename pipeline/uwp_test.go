@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"m4s-converter/conver"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// box 生成MP4盒子
+func box(typ string, payload ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(payload, nil))))
+	return append(append(b, typ...), bytes.Join(payload, nil)...)
+}
+
+// testTrack 生成时长为n秒的轨道，每秒一个样本
+func testTrack(handler, codec string, n int) *mp4.Track {
+	track := &mp4.Track{
+		Handler:     handler,
+		Timescale:   1000,
+		Language:    "und",
+		SampleEntry: box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, box(codec, make([]byte, 28))),
+		MediaTime:   -1,
+		Source:      bytes.NewReader(bytes.Repeat([]byte{1, 2, 3, 4}, n*10)),
+	}
+	for i := 0; i < n; i++ {
+		track.Samples = append(track.Samples, mp4.Sample{Offset: int64(i * 40), Size: 40, Duration: 1000, Sync: true})
+	}
+	return track
+}
+
+// testMP4 生成包含指定轨道的MP4文件
+func testMP4(t *testing.T, tracks ...*mp4.Track) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := mp4.Write(&buf, tracks, mp4.Tags{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUWPMediaFiles(t *testing.T) {
+	dir := t.TempDir()
+	video, audio := testMP4(t, testTrack(mp4.Video, "avc1", 10)), testMP4(t, testTrack(mp4.Audio, "mp4a", 10))
+	files := map[string][]byte{
+		"999_1_0.mp4":         append(bytes.Clone(uwpMagic), video...),
+		"999_1_1.mp4":         append(bytes.Clone(uwpMagic), audio...),
+		"plain.mp4":           video,         // 没有混淆字节
+		"999_1_0-video.mp4":   []byte("old"), // 上次修复的结果
+		"999_1.info":          []byte(`{"Title":"UWP合集","PartNo":"1"}`),
+		"seg/999_2_1.flv":     append(bytes.Clone(uwpMagic), "FLV\x01"...),
+		"seg/999_2_10.flv":    append(bytes.Clone(uwpMagic), "FLV\x01"...),
+		"seg/999_2_9.flv":     append(bytes.Clone(uwpMagic), "FLV\x01"...),
+		"seg/999_2_0-tmp.flv": []byte("FLV\x01"),
+	}
+	for name, b := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// DASH缓存：音视频各一个带混淆字节的mp4
+	got := UWPMediaFiles(dir)
+	want := []string{filepath.Join(dir, "999_1_0.mp4"), filepath.Join(dir, "999_1_1.mp4")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("音视频文件为 %v", got)
+	}
+	if !IsUWPDir(dir) {
+		t.Fatal("应识别为UWP分P目录")
+	}
+	ms, err := uwpIntermediates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dsts []string
+	for _, m := range ms {
+		if m.offset != int64(len(uwpMagic)) {
+			t.Fatalf("%s 跳过的字节数为 %d", m.dst, m.offset)
+		}
+		dsts = append(dsts, filepath.Base(m.dst))
+	}
+	if !reflect.DeepEqual(dsts, []string{"999_1_0" + conver.VideoSuffix, "999_1_1" + conver.AudioSuffix}) {
+		t.Fatalf("中间文件为 %v", dsts)
+	}
+	// 上次修复的结果大小与缓存文件不一致，重新修复
+	if err = (&Scanner{CachePath: dir}).FindUWPFiles(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"999_1_0" + conver.VideoSuffix: video, "999_1_1" + conver.AudioSuffix: audio} {
+		if b, _ := os.ReadFile(filepath.Join(dir, name)); !bytes.Equal(b, want) {
+			t.Fatalf("%s 的内容与去掉混淆字节后的文件不一致", name)
+		}
+	}
+
+	// 旧版缓存：flv分段按序号排序，_9 在 _10 之前
+	seg := filepath.Join(dir, "seg")
+	got = UWPMediaFiles(seg)
+	want = []string{filepath.Join(seg, "999_2_1.flv"), filepath.Join(seg, "999_2_9.flv"), filepath.Join(seg, "999_2_10.flv")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("flv分段为 %v", got)
+	}
+	if ms, err = uwpIntermediates(seg); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("flv分段应拆分为音视频两个中间文件，实际为%d个", len(ms))
+	}
+	base := filepath.Join(seg, "999_2_1")
+	for i, dst := range []string{base + conver.VideoSuffix, base + conver.AudioSuffix} {
+		if ms[i].dst != dst || !reflect.DeepEqual(ms[i].sources, want) || ms[i].tracks == nil {
+			t.Fatalf("中间文件为 %s，来源 %v", ms[i].dst, ms[i].sources)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 校验未通过时对输出文件的处理方式
+const (
+	OnVerifyFailKeep       = "keep"       // 保留输出文件，只在结果中标记
+	OnVerifyFailDelete     = "delete"     // 删除输出文件，条目记为失败
+	OnVerifyFailQuarantine = "quarantine" // 移动到输出目录下的隔离目录，条目记为失败
+)
+
+// QuarantineDir 输出目录下存放校验未通过文件的目录
+const QuarantineDir = "校验未通过"
+
+// verifyTolerance 时长比较允许的误差，音频和视频的最后一帧通常相差不到一秒
+const verifyTolerance = 2 * time.Second
+
+// Verification 合成后对输出文件的校验结果
+type Verification struct {
+	VideoTracks int           // 输出文件中的视频轨道数
+	AudioTracks int           // 输出文件中的音频轨道数
+	Video       time.Duration // 输出文件中视频轨道的时长
+	Audio       time.Duration // 输出文件中音频轨道的时长
+	SrcVideo    time.Duration // 源视频文件的时长
+	SrcAudio    time.Duration // 源音频文件的时长
+	Expected    time.Duration // 缓存信息中的视频时长，未知时为0
+	Problems    []string      // 发现的问题，为空时校验通过
+}
+
+// OK 校验是否通过
+func (v *Verification) OK() bool {
+	return v == nil || len(v.Problems) == 0
+}
+
+// Error 校验未通过的原因
+func (v *Verification) Error() string {
+	return "校验未通过: " + strings.Join(v.Problems, "；")
+}
+
+func (v *Verification) addf(format string, a ...any) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, a...))
+}
+
+// verifyOutput 检查输出文件是否恰好包含一个视频轨道和一个音频轨道，源文件是否短于缓存信息中的时长，
+// 输出文件是否短于源文件，以及输出文件中音频和视频轨道的时长是否相差过大。时长未知时跳过对应的检查。
+// MKV文件只能读取整体时长，音视频轨道的时长相同
+func verifyOutput(output string, srcVideo, srcAudio, expected time.Duration) (*Verification, error) {
+	v := &Verification{SrcVideo: srcVideo, SrcAudio: srcAudio, Expected: expected}
+	// 输出文件使用内置解析器读取，不依赖合成后端对MKV的支持
+	streams, err := probeFile(output)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range streams {
+		switch s.Type {
+		case StreamVideo:
+			v.VideoTracks++
+			v.Video = max(v.Video, s.Duration)
+		case StreamAudio:
+			v.AudioTracks++
+			v.Audio = max(v.Audio, s.Duration)
+		}
+	}
+
+	switch {
+	case v.AudioTracks == 0:
+		v.addf("缺少音频轨道")
+	case v.AudioTracks > 1:
+		v.addf("包含%d个音频轨道", v.AudioTracks)
+	}
+	if v.VideoTracks != 1 {
+		v.addf("包含%d个视频轨道", v.VideoTracks)
+	}
+	if v.Expected > 0 {
+		if shorter(v.SrcVideo, v.Expected) {
+			v.addf("源视频时长%s短于缓存信息中的%s，缓存可能不完整", clock(v.SrcVideo), clock(v.Expected))
+		}
+		if shorter(v.SrcAudio, v.Expected) {
+			v.addf("源音频时长%s短于缓存信息中的%s，缓存可能不完整", clock(v.SrcAudio), clock(v.Expected))
+		}
+	}
+	if shorter(v.Video, v.SrcVideo) {
+		v.addf("视频轨道时长%s短于源视频的%s", clock(v.Video), clock(v.SrcVideo))
+	}
+	if shorter(v.Audio, v.SrcAudio) {
+		v.addf("音频轨道时长%s短于源音频的%s", clock(v.Audio), clock(v.SrcAudio))
+	}
+	if drift := (v.Video - v.Audio).Abs(); v.Video > 0 && v.Audio > 0 && drift > verifyTolerance {
+		v.addf("音视频轨道时长相差%s(视频%s，音频%s)", clock(drift), clock(v.Video), clock(v.Audio))
+	}
+	return v, nil
+}
+
+// shorter d是否比want短出误差以上，时长未知时返回false
+func shorter(d, want time.Duration) bool {
+	return d > 0 && want > 0 && want-d > verifyTolerance
+}
+
+// clock 将时长格式化为 mm:ss.s
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%04.1f", int(d.Minutes()), (d % time.Minute).Seconds())
+}
+
+// quarantinePath 校验未通过的输出文件在隔离目录中的路径，保留相对于输出目录的路径
+func quarantinePath(output, outputDir string) string {
+	rel, err := filepath.Rel(outputDir, output)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(output)
+	}
+	return filepath.Join(outputDir, QuarantineDir, rel)
+}
@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMuxer 按指定的时长合成只含样本时长的MP4文件，用于测试合成后的校验
+type fakeMuxer struct {
+	video, audio time.Duration // 源文件中音视频流的时长
+	outVideo     int           // 输出文件中视频轨道的秒数
+	outAudio     int           // 输出文件中音频轨道的秒数
+}
+
+func (m *fakeMuxer) Name() string { return "fake" }
+
+func (m *fakeMuxer) Probe(_ context.Context, file string) ([]Stream, error) {
+	if strings.Contains(file, "video") {
+		return []Stream{{Type: StreamVideo, Duration: m.video}}, nil
+	}
+	return []Stream{{Type: StreamAudio, Duration: m.audio}}, nil
+}
+
+func (m *fakeMuxer) Mux(_ context.Context, job *Job) error {
+	var buf bytes.Buffer
+	tracks := []*mp4.Track{testTrack(mp4.Video, "avc1", m.outVideo), testTrack(mp4.Audio, "mp4a", m.outAudio)}
+	if err := mp4.Write(&buf, tracks, mp4.Tags{}); err != nil {
+		return err
+	}
+	return os.WriteFile(job.Output, buf.Bytes(), 0o644)
+}
+
+func (m *fakeMuxer) Tag(context.Context, string, mp4.Tags) error { return nil }
+
+func (m *fakeMuxer) Verify(context.Context, string) error { return nil }
+
+func TestCompositionVerify(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name     string
+		muxer    fakeMuxer
+		expected time.Duration
+		policy   string
+		problem  string // 校验问题中应包含的内容，为空时校验通过
+	}{
+		{name: "通过", muxer: fakeMuxer{10 * s, 10 * s, 10, 10}, expected: 10 * s},
+		{name: "误差以内", muxer: fakeMuxer{10 * s, 9 * s, 10, 9}},
+		// 源文件的音视频时长相差较大，以输出文件中的轨道时长判断
+		{name: "输出的音视频不同步", muxer: fakeMuxer{10 * s, 5 * s, 10, 5}, policy: OnVerifyFailKeep, problem: "音视频轨道时长相差00:05.0"},
+		{name: "输出短于源文件", muxer: fakeMuxer{10 * s, 10 * s, 10, 6}, policy: OnVerifyFailDelete, problem: "音频轨道时长00:06.0短于源音频"},
+		{name: "缓存不完整", muxer: fakeMuxer{5 * s, 5 * s, 5, 5}, expected: 60 * s, policy: OnVerifyFailQuarantine, problem: "缓存可能不完整"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			job := &Job{
+				Video:        filepath.Join(dir, "video.m4s"),
+				Audio:        filepath.Join(dir, "audio.m4s"),
+				Output:       filepath.Join(dir, "output", "a.mp4"),
+				Expected:     tt.expected,
+				OnVerifyFail: tt.policy,
+				Quarantine:   quarantinePath(filepath.Join(dir, "output", "a.mp4"), filepath.Join(dir, "output")),
+			}
+			if err := os.MkdirAll(filepath.Dir(job.Output), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			err := Composition(context.Background(), &tt.muxer, job)
+			if job.Verify == nil {
+				t.Fatalf("没有校验结果: %v", err)
+			}
+			if tt.problem == "" {
+				if err != nil || !job.Verify.OK() || Size(job.Output) == 0 {
+					t.Fatalf("校验应通过: %v %v", err, job.Verify.Problems)
+				}
+				return
+			}
+			if job.Verify.OK() || !strings.Contains(job.Verify.Error(), tt.problem) {
+				t.Fatalf("校验结果为 %v", job.Verify.Problems)
+			}
+			var v *Verification
+			switch tt.policy {
+			case OnVerifyFailKeep:
+				if err != nil || Size(job.Output) == 0 {
+					t.Fatalf("应保留输出文件: %v", err)
+				}
+			case OnVerifyFailDelete:
+				if !errors.As(err, &v) || Size(job.Output) != 0 {
+					t.Fatalf("应删除输出文件: %v", err)
+				}
+			case OnVerifyFailQuarantine:
+				if err != nil || Size(job.Output) != 0 || Size(job.Quarantine) == 0 {
+					t.Fatalf("应移到隔离目录: %v", err)
+				}
+			}
+			// 临时文件都已删除或重命名
+			entries, _ := os.ReadDir(filepath.Dir(job.Output))
+			for _, e := range entries {
+				if !e.IsDir() && e.Name() != filepath.Base(job.Output) {
+					t.Fatalf("输出目录中残留文件: %s", e.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"cmp"
+	"fmt"
+	"m4s-converter/conver"
+	"m4s-converter/mp4"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 视频编码
+const (
+	CodecAVC  = "avc"
+	CodecHEVC = "hevc"
+	CodecAV1  = "av1"
+)
+
+// DefaultPrefer 默认选择分辨率最高的视频，分辨率相同时选择码率最高的
+const DefaultPrefer = "max-resolution,max-bitrate"
+
+// VideoStream 一个视频流，同一视频可能缓存了多个清晰度或编码
+type VideoStream struct {
+	ID        string
+	File      string // 已修复的视频文件，只在.playurl中出现时为空
+	Codec     string // avc、hevc、av1
+	Width     int
+	Height    int
+	Bandwidth int64 // 码率，单位为bit/s
+	Size      int64 // 文件大小
+}
+
+func (v VideoStream) String() string {
+	return fmt.Sprintf("%s %dx%d %dkbps", v.Codec, v.Width, v.Height, v.Bandwidth/1000)
+}
+
+// videoCodec 将.playurl的codecs或MP4的样本描述类型统一为avc、hevc、av1
+func videoCodec(codec string) string {
+	switch c, _, _ := strings.Cut(codec, "."); c {
+	case "avc1", "avc3":
+		return CodecAVC
+	case "hev1", "hvc1":
+		return CodecHEVC
+	case "av01":
+		return CodecAV1
+	default:
+		return c
+	}
+}
+
+// listVideos 列出目录中所有已修复的视频文件，编码、分辨率和码率从文件中读取，
+// .playurl中有对应的流时使用其中的码率，演练模式下包括待生成的视频文件
+func (s *Scanner) listVideos(dir string) []VideoStream {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var pu *PlayUrl
+	if p, err := ReadPlayUrl(filepath.Join(dir, conver.PlayUrlSuffix)); err == nil {
+		pu = p
+	}
+	files := s.pendingIn(dir, conver.VideoSuffix)
+	for _, e := range entries {
+		file := filepath.Join(dir, e.Name())
+		if !e.IsDir() && strings.HasSuffix(e.Name(), conver.VideoSuffix) && !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	var videos []VideoStream
+	for _, file := range files {
+		v, err := s.probeVideo(file)
+		if err != nil {
+			logrus.Warnf("无法读取视频文件 %s: %v", file, err)
+			continue
+		}
+		if pu != nil {
+			m4s := strings.TrimSuffix(filepath.Base(file), conver.VideoSuffix) + conver.M4sSuffix
+			for _, p := range pu.Video {
+				if matchM4s(m4s, p.ID) && p.Codec == v.Codec {
+					v.ID = p.ID
+					v.Bandwidth = cmp.Or(p.Bandwidth, v.Bandwidth)
+					break
+				}
+			}
+		}
+		videos = append(videos, v)
+	}
+	return videos
+}
+
+// probeVideo 使用内置解析器读取视频文件的编码、分辨率和平均码率
+func (s *Scanner) probeVideo(file string) (VideoStream, error) {
+	v := VideoStream{File: file}
+	tracks, c, err := s.OpenTracks(file)
+	if err != nil {
+		return v, err
+	}
+	defer c.Close()
+	i := slices.IndexFunc(tracks, func(t *mp4.Track) bool { return t.Handler == mp4.Video })
+	if i < 0 {
+		return v, fmt.Errorf("文件中找不到%s流", StreamVideo)
+	}
+	t := tracks[i]
+	v.Codec = videoCodec(t.Codec)
+	v.Width, v.Height = int(t.Width), int(t.Height)
+	v.Size = t.Size()
+	if d := t.Time().Seconds(); d > 0 {
+		v.Bandwidth = int64(float64(v.Size*8) / d)
+	}
+	return v, nil
+}
+
+// Prefer 视频流选择策略，由逗号分隔的规则组成，前面的规则优先：
+//
+//	hevc>avc>av1   按编码排序，未列出的编码排在最后
+//	max-resolution 分辨率最高
+//	min-resolution 分辨率最低
+//	max-bitrate    码率最高
+//	smallest       文件最小
+type Prefer []func(a, b VideoStream) int
+
+// ParsePrefer 解析视频流选择策略
+func ParsePrefer(s string) (Prefer, error) {
+	var p Prefer
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		switch rule {
+		case "":
+		case "max-resolution":
+			p = append(p, func(a, b VideoStream) int { return cmp.Compare(b.Width*b.Height, a.Width*a.Height) })
+		case "min-resolution":
+			p = append(p, func(a, b VideoStream) int { return cmp.Compare(a.Width*a.Height, b.Width*b.Height) })
+		case "max-bitrate":
+			p = append(p, func(a, b VideoStream) int { return cmp.Compare(b.Bandwidth, a.Bandwidth) })
+		case "smallest":
+			p = append(p, func(a, b VideoStream) int { return cmp.Compare(a.Size, b.Size) })
+		default:
+			order := strings.Split(rule, ">")
+			for _, c := range order {
+				if c != CodecAVC && c != CodecHEVC && c != CodecAV1 {
+					return nil, fmt.Errorf("不支持的视频选择策略: %s", rule)
+				}
+			}
+			rank := func(codec string) int {
+				if i := slices.Index(order, codec); i >= 0 {
+					return i
+				}
+				return len(order)
+			}
+			p = append(p, func(a, b VideoStream) int { return cmp.Compare(rank(a.Codec), rank(b.Codec)) })
+		}
+	}
+	return p, nil
+}
+
+// Select 按策略选择一个视频流
+func (p Prefer) Select(videos []VideoStream) *VideoStream {
+	if len(videos) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(videos)
+	slices.SortStableFunc(sorted, func(a, b VideoStream) int {
+		for _, f := range p {
+			if c := f(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return &sorted[0]
+}
@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"io/fs"
+	"m4s-converter/bilicache"
+	"m4s-converter/conver"
+	"m4s-converter/internal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWatchDelay 监视模式下目录最后一次变化后等待的时间，避免读取正在写入的m4s文件
+const DefaultWatchDelay = 10 * time.Second
+
+// Watch 监视缓存目录，视频信息或音视频文件有变化的条目在缓存完成且delay内没有新的变化后合成，
+// 每次合成后调用done。ctx取消时返回
+func (p *Pipeline) Watch(ctx context.Context, delay time.Duration, done func(*Result, error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err = watchTree(w, p.Scanner.CachePath); err != nil {
+		return err
+	}
+	outputDir := p.Output()
+	logrus.Info("正在监视缓存目录: ", p.Scanner.CachePath)
+
+	changed := map[string]time.Time{} // 条目目录 → 最后一次变化的时间
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-w.Errors:
+			logrus.Warn("监视缓存目录异常: ", err)
+		case ev := <-w.Events:
+			if strings.HasPrefix(ev.Name, outputDir) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) && isDir(ev.Name) {
+				// 新下载的视频目录，其中已有的文件按变化处理
+				if err := watchTree(w, ev.Name); err != nil {
+					logrus.Warn("监视目录失败: ", err)
+				}
+				if dir := itemDir(ev.Name, p.Scanner.CachePath); dir != "" {
+					changed[dir] = time.Now()
+				}
+				continue
+			}
+			if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Rename) {
+				continue
+			}
+			if !watchedFile(filepath.Base(ev.Name)) {
+				continue
+			}
+			if dir := itemDir(filepath.Dir(ev.Name), p.Scanner.CachePath); dir != "" {
+				changed[dir] = time.Now()
+			}
+		case <-ticker.C:
+			for dir, t := range changed {
+				if time.Since(t) < delay {
+					continue
+				}
+				delete(changed, dir)
+				if !dirCompleted(dir) {
+					logrus.Info("未缓存完成，等待下载: ", dir)
+					continue
+				}
+				logrus.Info("缓存已完成，开始合成: ", dir)
+				res, err := p.RunDir(ctx, dir, outputDir)
+				done(res, err)
+			}
+		}
+	}
+}
+
+// RunDir 只合成一个条目目录，输出到outputDir
+func (p *Pipeline) RunDir(ctx context.Context, dir, outputDir string) (*Result, error) {
+	s := *p.Scanner
+	s.CachePath, s.pending = dir, nil
+	sub := *p
+	sub.Scanner, sub.OutputDir = &s, outputDir
+	return sub.Run(ctx)
+}
+
+// watchTree 监视root及其所有子目录，不包括输出目录
+func watchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if path != root && strings.Contains(path, "output") {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+// watchedFile 是否为客户端下载时写入的文件，合成时生成的中间文件、临时文件和弹幕不触发合成
+func watchedFile(name string) bool {
+	if internal.IsTemp(name) {
+		return false
+	}
+	switch name {
+	case conver.VideoInfoJson, conver.VideoInfoSuffix, conver.PlayUrlSuffix, conver.PlayEntryJson, bilicache.IndexJson:
+		return true
+	}
+	if strings.HasSuffix(name, conver.VideoSuffix) || strings.HasSuffix(name, conver.AudioSuffix) {
+		return false
+	}
+	switch filepath.Ext(name) {
+	case conver.M4sSuffix, conver.BlvSuffix, conver.VideoInfoSuffix, conver.PlayUrlSuffix, bilicache.UWPInfoSuffix, conver.Mp4Suffix, ".flv":
+		return true
+	}
+	return false
+}
+
+// itemDir 返回path所在的条目目录，即从path向上第一个有视频信息文件的目录
+func itemDir(path, root string) string {
+	for dir := path; strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if infoFile(dir) != "" {
+			return dir
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return ""
+}
+
+// dirCompleted 条目是否已缓存完成
+func dirCompleted(dir string) bool {
+	meta, err := bilicache.ReadVideoMeta(infoFile(dir))
+	if err != nil {
+		return false
+	}
+	return (&Item{Status: Filter(meta.Status, nil)}).Completed()
+}
@@ -0,0 +1,27 @@
+package pipeline
+
+import "testing"
+
+func TestWatchedFile(t *testing.T) {
+	tests := map[string]bool{
+		"videoInfo.json":          true,
+		"entry.json":              true,
+		".playurl":                true,
+		"30080.m4s":               true,
+		"0.blv":                   true,
+		"999_1.info":              true,
+		"999_1_0.mp4":             true,
+		"999_2_0.flv":             true,
+		"30080-video.mp4":         false,
+		"30280-audio.mp3":         false,
+		"danmaku.xml":             false,
+		".30080-video.42.tmp.mp4": false,
+		".30280.42.tmp.m4s":       false,
+		".entry.42.tmp.json":      false,
+	}
+	for name, want := range tests {
+		if got := watchedFile(name); got != want {
+			t.Errorf("watchedFile(%q) = %v, 期望 %v", name, got, want)
+		}
+	}
+}
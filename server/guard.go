@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckListen 检查监听地址。服务可以读取缓存和输出目录并启动合成，监听非本机地址时必须设置访问令牌
+func CheckListen(addr, token string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("监听地址格式错误: %s: %v", addr, err)
+	}
+	if !LocalOnly(addr) && token == "" {
+		return fmt.Errorf("监听地址%s不是本机地址，局域网访问需要用--token设置访问令牌", addr)
+	}
+	return nil
+}
+
+// LocalOnly 监听地址是否只允许本机访问
+func LocalOnly(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	return loopback(host)
+}
+
+// loopback host是否为本机地址
+func loopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localHosts 浏览器访问监听地址时请求中可能的Host，即监听端口上的各种本机地址
+func localHosts(addr string) map[string]bool {
+	host, port, _ := net.SplitHostPort(addr)
+	hosts := map[string]bool{}
+	for _, h := range []string{host, "localhost", "127.0.0.1", "::1"} {
+		h = strings.ToLower(h)
+		hostport := net.JoinHostPort(h, port)
+		hosts[hostport] = true
+		if port == "80" { // 默认端口时浏览器省略端口
+			hosts[strings.TrimSuffix(hostport, ":80")] = true
+		}
+	}
+	return hosts
+}
+
+// authorized 请求是否提供了访问令牌，令牌可以为Bearer令牌或Basic认证的密码，未设置令牌时不验证
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// allowedHost 是否允许请求的Host。只监听本机地址时为监听端口上的本机地址；监听局域网地址时请求已验证令牌，
+// 允许任意Host，Origin必须与Host相同
+func (s *Server) allowedHost(host string, r *http.Request) bool {
+	if s.hosts == nil {
+		return strings.EqualFold(host, r.Host)
+	}
+	return s.hosts[strings.ToLower(host)]
+}
+
+// guard 验证访问令牌，拒绝Host或Origin不是监听地址的请求，防止其他网站通过浏览器跨站提交或DNS重绑定访问服务。
+// POST请求必须为JSON，跨站的表单无法提交
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="m4s-converter", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, errors.New("访问令牌错误"))
+			return
+		}
+		if !s.allowedHost(r.Host, r) {
+			writeError(w, http.StatusForbidden, errors.New("不允许的Host: "+r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || !s.allowedHost(u.Host, r) {
+				writeError(w, http.StatusForbidden, errors.New("不允许跨站请求: "+origin))
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("请求体必须为application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
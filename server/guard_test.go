@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckListen(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:8080", "", true},
+		{"[::1]:8080", "", true},
+		{"localhost:9000", "", true},
+		{"127.0.0.1:8080", "secret", true},
+		{"0.0.0.0:8080", "", false},
+		{":8080", "", false},
+		{"192.168.1.2:80", "", false},
+		{"example.com:80", "", false},
+		{"0.0.0.0:8080", "secret", true},
+		{":8080", "secret", true},
+		{"192.168.1.2:80", "secret", true},
+		{"127.0.0.1", "", false},
+		{"0.0.0.0", "secret", false},
+	}
+	for _, tt := range tests {
+		if err := CheckListen(tt.addr, tt.token); (err == nil) != tt.ok {
+			t.Errorf("CheckListen(%q, %q) = %v", tt.addr, tt.token, err)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	s := New(context.Background(), "127.0.0.1:8080", "", nil)
+	h := s.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{"网页", http.MethodGet, "127.0.0.1:8080", "", "", http.StatusOK},
+		{"localhost", http.MethodGet, "localhost:8080", "", "", http.StatusOK},
+		{"启动任务", http.MethodPost, "127.0.0.1:8080", "http://127.0.0.1:8080", "application/json", http.StatusOK},
+		{"带charset", http.MethodPost, "localhost:8080", "http://localhost:8080", "application/json; charset=utf-8", http.StatusOK},
+		{"DNS重绑定", http.MethodGet, "evil.example:8080", "", "", http.StatusForbidden},
+		{"其他端口", http.MethodGet, "127.0.0.1:9090", "", "", http.StatusForbidden},
+		{"跨站请求", http.MethodPost, "127.0.0.1:8080", "http://evil.example", "application/json", http.StatusForbidden},
+		{"Origin为null", http.MethodPost, "127.0.0.1:8080", "null", "application/json", http.StatusForbidden},
+		{"表单", http.MethodPost, "127.0.0.1:8080", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text/plain", http.MethodPost, "127.0.0.1:8080", "http://127.0.0.1:8080", "text/plain", http.StatusUnsupportedMediaType},
+		{"没有Content-Type", http.MethodPost, "127.0.0.1:8080", "", "", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/jobs", strings.NewReader("{}"))
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("状态码为 %d, 期望 %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestGuardToken(t *testing.T) {
+	s := New(context.Background(), "0.0.0.0:8080", "secret", nil)
+	h := s.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		auth   func(r *http.Request)
+		want   int
+	}{
+		{"Bearer令牌", "192.168.1.2:8080", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"Basic认证", "nas.lan:8080", "http://nas.lan:8080", func(r *http.Request) { r.SetBasicAuth("任意", "secret") }, http.StatusOK},
+		{"没有令牌", "192.168.1.2:8080", "", func(*http.Request) {}, http.StatusUnauthorized},
+		{"令牌错误", "192.168.1.2:8080", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secre") }, http.StatusUnauthorized},
+		{"密码错误", "192.168.1.2:8080", "", func(r *http.Request) { r.SetBasicAuth("secret", "") }, http.StatusUnauthorized},
+		{"跨站请求", "192.168.1.2:8080", "http://evil.example", func(r *http.Request) { r.SetBasicAuth("", "secret") }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/jobs", strings.NewReader("{}"))
+			r.Host = tt.host
+			r.Header.Set("Content-Type", "application/json")
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			tt.auth(r)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("状态码为 %d, 期望 %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("应提示浏览器输入令牌")
+			}
+		})
+	}
+}
+
+func TestLocalHostsDefaultPort(t *testing.T) {
+	hosts := localHosts("[::1]:80")
+	for _, h := range []string{"[::1]", "[::1]:80", "localhost", "127.0.0.1:80"} {
+		if !hosts[h] {
+			t.Errorf("应允许 %s", h)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"m4s-converter/pipeline"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 任务状态
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobCanceled = "canceled"
+	JobFailed   = "failed"
+)
+
+// Job 一次合成任务，条目的结果在处理完成时逐个加入
+type Job struct {
+	id     int
+	dirs   []string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	state   string
+	err     error
+	begin   time.Time
+	end     time.Time
+	items   []pipeline.ReportItem
+	prog    ProgressInfo
+	version int           // 进度的版本，每次更新加1
+	changed chan struct{} // 有新的结果、进度或状态变化时关闭并替换
+}
+
+// ProgressInfo 任务的整体进度和最近报告进度的文件
+type ProgressInfo struct {
+	Done        int     `json:"done"`
+	Total       int     `json:"total"`
+	Percent     float64 `json:"percent"`
+	ETA         float64 `json:"eta"`            // 估算的剩余时间，秒
+	File        string  `json:"file,omitempty"` // 正在生成的中间文件或合成的输出文件
+	FilePercent float64 `json:"filePercent"`
+}
+
+// JobInfo 任务的状态和已处理条目的结果
+type JobInfo struct {
+	ID       int                   `json:"id"`
+	Dirs     []string              `json:"dirs,omitempty"` // 为空时合成整个缓存目录
+	State    string                `json:"state"`
+	Error    string                `json:"error,omitempty"`
+	Begin    time.Time             `json:"begin"`
+	End      *time.Time            `json:"end,omitempty"`
+	Progress ProgressInfo          `json:"progress"`
+	Items    []pipeline.ReportItem `json:"items"`
+}
+
+func newJob(ctx context.Context, id int, dirs []string) *Job {
+	j := &Job{id: id, dirs: dirs, state: JobRunning, begin: time.Now(), changed: make(chan struct{})}
+	j.ctx, j.cancel = context.WithCancel(ctx)
+	return j
+}
+
+// run 执行合成，指定了目录时逐个合成到同一个输出目录
+func (j *Job) run(p *pipeline.Pipeline) {
+	defer j.cancel()
+	p.OnEvent = j.event
+	logrus.Infof("任务%d开始合成", j.id)
+	var err error
+	if len(j.dirs) == 0 {
+		_, err = p.Run(j.ctx)
+	}
+	for _, dir := range j.dirs {
+		if _, err = p.RunDir(j.ctx, dir, p.Output()); err != nil {
+			break
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.end, j.err = time.Now(), err
+	switch {
+	case errors.Is(err, context.Canceled) || j.ctx.Err() != nil:
+		j.state = JobCanceled
+		logrus.Infof("任务%d已取消", j.id)
+	case err != nil:
+		j.state = JobFailed
+		logrus.Errorf("任务%d失败: %v", j.id, err)
+	default:
+		j.state = JobDone
+		logrus.Infof("任务%d已完成，处理了%d个条目", j.id, len(j.items))
+	}
+	j.notify()
+}
+
+// event 记录合成流程的进度事件
+func (j *Job) event(e pipeline.Event) {
+	var item *pipeline.ReportItem
+	if e, ok := e.(pipeline.ItemEvent); ok {
+		r := pipeline.NewReportItem(e.Result)
+		item = &r
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch e := e.(type) {
+	case pipeline.CopyEvent:
+		j.prog.File = e.File
+		j.prog.FilePercent = float64(e.Bytes) / float64(max(e.Total, 1)) * 100
+	case pipeline.MuxEvent:
+		j.setProgress(e.Progress)
+		j.prog.File, j.prog.FilePercent = e.Output, e.Percent
+	case pipeline.ItemEvent:
+		j.setProgress(e.Progress)
+		j.items = append(j.items, *item)
+	}
+	j.version++
+	j.notify()
+}
+
+// setProgress 更新整体进度，调用时需持有锁
+func (j *Job) setProgress(p pipeline.Progress) {
+	j.prog.Done, j.prog.Total, j.prog.Percent, j.prog.ETA = p.Done, p.Total, p.Percent, p.ETA.Seconds()
+}
+
+// notify 通知等待进度的请求，调用时需持有锁
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// since 返回第n个之后的结果、当前进度及其版本、任务是否已结束，以及下次变化时关闭的通道
+func (j *Job) since(n int) ([]pipeline.ReportItem, ProgressInfo, int, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.items[n:], j.prog, j.version, j.state != JobRunning, j.changed
+}
+
+// Info 返回任务的快照
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := JobInfo{
+		ID:       j.id,
+		Dirs:     j.dirs,
+		State:    j.state,
+		Begin:    j.begin,
+		Progress: j.prog,
+		Items:    append([]pipeline.ReportItem{}, j.items...),
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if !j.end.IsZero() {
+		end := j.end
+		info.End = &end
+	}
+	return info
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"m4s-converter/internal"
+	"m4s-converter/pipeline"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultListen 默认监听地址，只允许本机访问
+const DefaultListen = "127.0.0.1:8080"
+
+//go:embed web
+var web embed.FS
+
+// Server 本地HTTP服务，提供列出缓存条目、启动和取消合成任务、推送合成进度和浏览输出文件的接口，
+// 以及使用这些接口的网页。扫描和合成与命令行使用相同的流程
+type Server struct {
+	ctx         context.Context
+	newPipeline func() *pipeline.Pipeline
+	hosts       map[string]bool // 允许的Host，监听局域网地址时为nil，见 guard
+	token       string          // 访问令牌，为空时不验证
+
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+// New 创建服务，listen为监听地址，token为访问令牌，newPipeline 为每次扫描或合成创建新的流程，
+// ctx取消时正在运行的任务随之取消
+func New(ctx context.Context, listen, token string, newPipeline func() *pipeline.Pipeline) *Server {
+	s := &Server{ctx: ctx, newPipeline: newPipeline, token: token}
+	if LocalOnly(listen) {
+		s.hosts = localHosts(listen)
+	}
+	return s
+}
+
+// Handler 返回服务的路由
+func (s *Server) Handler() http.Handler {
+	static, _ := fs.Sub(web, "web")
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(static))
+	mux.HandleFunc("GET /api/items", s.listItems)
+	mux.HandleFunc("GET /api/jobs", s.listJobs)
+	mux.HandleFunc("POST /api/jobs", s.startJob)
+	mux.HandleFunc("GET /api/jobs/{id}", s.getJob)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.cancelJob)
+	mux.HandleFunc("GET /api/jobs/{id}/events", s.jobEvents)
+	mux.HandleFunc("GET /api/outputs", s.listOutputs)
+	mux.Handle("GET /files/", http.StripPrefix("/files/", http.FileServer(http.Dir(s.newPipeline().Output()))))
+	return s.guard(mux)
+}
+
+// ItemInfo 缓存条目的信息
+type ItemInfo struct {
+	Dir        string `json:"dir"`
+	GroupTitle string `json:"groupTitle"`
+	Title      string `json:"title"`
+	Name       string `json:"name"`
+	Uname      string `json:"uname"`
+	Completed  bool   `json:"completed"`
+	Video      string `json:"video,omitempty"`
+	Audio      string `json:"audio,omitempty"`
+	Output     string `json:"output"`    // 合成后的输出文件
+	Converted  bool   `json:"converted"` // 输出文件是否已存在
+}
+
+// listItems 列出缓存目录中的条目，扫描时不写入任何文件
+func (s *Server) listItems(w http.ResponseWriter, r *http.Request) {
+	p := s.newPipeline()
+	p.Scanner.DryRun = true
+	items, err := p.Scanner.Scan(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := []ItemInfo{}
+	for _, it := range items {
+		output := p.OutputFile(it, p.Output(), p.Ext())
+		list = append(list, ItemInfo{
+			Dir:        it.Dir,
+			GroupTitle: it.GroupTitle,
+			Title:      it.Title,
+			Name:       it.Name(),
+			Uname:      it.Uname,
+			Completed:  it.Completed(),
+			Video:      it.Video,
+			Audio:      it.Audio,
+			Output:     output,
+			Converted:  pipeline.Size(output) > 0,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// listJobs 列出所有任务
+func (s *Server) listJobs(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	list := make([]JobInfo, len(s.jobs))
+	for i, j := range s.jobs {
+		list[i] = j.Info()
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+// startJob 启动合成任务，dirs为空时合成整个缓存目录。同一时间只运行一个任务
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Dirs []string `json:"dirs"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	p := s.newPipeline()
+	for _, dir := range req.Dirs {
+		if !within(p.Scanner.CachePath, dir) {
+			writeError(w, http.StatusBadRequest, errors.New("目录不在缓存目录中: "+dir))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if j.Info().State == JobRunning {
+			s.mu.Unlock()
+			writeError(w, http.StatusConflict, errors.New("已有正在运行的任务"))
+			return
+		}
+	}
+	j := newJob(s.ctx, len(s.jobs)+1, req.Dirs)
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	go j.run(p)
+	writeJSON(w, http.StatusAccepted, j.Info())
+}
+
+// getJob 返回任务的状态和已处理条目的结果
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, j.Info())
+}
+
+// cancelJob 取消任务，正在合成的条目完成后停止
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.cancel()
+	writeJSON(w, http.StatusAccepted, j.Info())
+}
+
+// jobEvents 以SSE推送任务进度，先发送已处理的条目，每个条目完成时发送item事件，
+// 整体进度或正在处理的文件的进度变化时发送progress事件，任务结束时发送done事件
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("不支持推送事件"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent, version := 0, 0
+	for {
+		items, prog, v, finished, changed := j.since(sent)
+		for _, it := range items {
+			writeEvent(w, "item", it)
+		}
+		sent += len(items)
+		if v != version {
+			writeEvent(w, "progress", prog)
+			version = v
+		}
+		if finished {
+			writeEvent(w, "done", j.Info())
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// OutputFile 输出目录中的文件
+type OutputFile struct {
+	Path    string    `json:"path"` // 相对于输出目录的路径，可通过 /files/ 访问
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// listOutputs 列出输出目录中的文件，不包括索引文件和正在写入的临时文件
+func (s *Server) listOutputs(w http.ResponseWriter, _ *http.Request) {
+	root := s.newPipeline().Output()
+	list := []OutputFile{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == pipeline.IndexFile || internal.IsTemp(d.Name()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		list = append(list, OutputFile{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// job 根据路径中的id查找任务，找不到时写入404
+func (s *Server) job(w http.ResponseWriter, r *http.Request) *Job {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || id < 1 || id > len(s.jobs) {
+		writeError(w, http.StatusNotFound, errors.New("任务不存在"))
+		return nil
+	}
+	return s.jobs[id-1]
+}
+
+// within dir是否为root或其子目录
+func within(root, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && filepath.IsAbs(dir) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warn("写入响应失败: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// writeEvent 写入一个SSE事件
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logrus.Warn("编码事件失败: ", err)
+		return
+	}
+	_, _ = w.Write([]byte("event: " + event + "\ndata: " + string(b) + "\n\n"))
+}